@@ -0,0 +1,207 @@
+package archive
+
+import(
+  "github.com/pjrebsch/mizudiff/tree"
+  "archive/tar"
+  "archive/zip"
+  "bytes"
+  "compress/gzip"
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path"
+  "strings"
+)
+
+// Format identifies how a piece of data is packaged.
+type Format int
+
+const (
+  Plain Format = iota
+  Gzip
+  Tar
+  Zip
+)
+
+var gzipMagic = []byte{ 0x1f, 0x8b }
+var zipMagic = []byte("PK\x03\x04")
+var zipEmptyMagic = []byte("PK\x05\x06")
+
+// tarMagicOffset is where the "ustar" magic of a POSIX tar header begins.
+const tarMagicOffset = 257
+
+// Detect identifies the format of `raw` from its leading bytes.
+func Detect(raw []byte) Format {
+  switch {
+  case bytes.HasPrefix(raw, gzipMagic):
+    return Gzip
+  case bytes.HasPrefix(raw, zipMagic) || bytes.HasPrefix(raw, zipEmptyMagic):
+    return Zip
+  case len(raw) >= tarMagicOffset + 5 &&
+    bytes.Equal(raw[tarMagicOffset:tarMagicOffset+5], []byte("ustar")):
+    return Tar
+  }
+  return Plain
+}
+
+// MaxSize is the most data that is decompressed, or read from all of the
+// members of an archive together, so that a small archive can't unpack
+// into all of memory.
+var MaxSize = int64(1 << 30)
+
+// ErrTooLarge is returned for data that unpacks to more than MaxSize.
+var ErrTooLarge = errors.New("archive data is larger than allowed")
+
+// ErrUnsupported is returned for tar members that are neither regular
+// files, directories nor symbolic links, such as hard links and devices.
+var ErrUnsupported = errors.New("archive member type is not supported")
+
+// Member is a single file packaged in an archive.
+type Member struct {
+  Path string
+  Mode os.FileMode
+  Data []byte
+}
+
+// Decompress returns the uncompressed contents of gzip data, and any other
+// data as is.
+func Decompress(raw []byte) ([]byte, error) {
+  if Detect(raw) != Gzip {
+    return raw, nil
+  }
+  r, err := gzip.NewReader(bytes.NewReader(raw))
+  if err != nil {
+    return nil, err
+  }
+  defer r.Close()
+  return readAll(r, MaxSize)
+}
+
+// readAll reads `r` to the end, or returns ErrTooLarge once it has read
+// more than `left`, which is what remains of MaxSize.
+func readAll(r io.Reader, left int64) ([]byte, error) {
+  data, err := ioutil.ReadAll(io.LimitReader(r, left + 1))
+  if err != nil {
+    return nil, err
+  }
+  if int64(len(data)) > left {
+    return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, MaxSize)
+  }
+  return data, nil
+}
+
+// Members unpacks `raw`, decompressing it first if needed. Data that isn't
+// a tar or zip archive comes back as a single member named `name`, less any
+// ".gz" suffix.
+func Members(raw []byte, name string) ([]Member, error) {
+  if Detect(raw) == Gzip {
+    var err error
+    raw, err = Decompress(raw)
+    if err != nil {
+      return nil, err
+    }
+    name = strings.TrimSuffix(name, ".gz")
+  }
+
+  switch Detect(raw) {
+  case Tar:
+    return tarMembers(raw)
+  case Zip:
+    return zipMembers(raw)
+  }
+  return []Member{ { name, 0644, raw } }, nil
+}
+
+// Tree digests every member of `raw`, as unpacked by Members.
+func Tree(raw []byte, name string) (tree.Tree, error) {
+  return TreeContext(context.Background(), raw, name)
+}
+
+// TreeContext is Tree, but stops digesting when the context is done.
+func TreeContext(ctx context.Context, raw []byte, name string) (tree.Tree, error) {
+  ms, err := Members(raw, name)
+  if err != nil {
+    return tree.Tree{}, err
+  }
+
+  t := tree.Tree{ Entries: []tree.Entry{} }
+  for _, m := range ms {
+    if err := t.AddContext(ctx, m.Path, m.Mode, m.Data); err != nil {
+      return tree.Tree{}, err
+    }
+  }
+  return t, nil
+}
+
+func tarMembers(raw []byte) ([]Member, error) {
+  ms := []Member{}
+  r := tar.NewReader(bytes.NewReader(raw))
+  left := MaxSize
+
+  for {
+    h, err := r.Next()
+    if err == io.EOF {
+      return ms, nil
+    }
+    if err != nil {
+      return nil, err
+    }
+
+    switch h.Typeflag {
+    case tar.TypeReg:
+      data, err := readAll(r, left)
+      if err != nil {
+        return nil, fmt.Errorf("%s: %w", h.Name, err)
+      }
+      left -= int64(len(data))
+      ms = append(ms, Member{ cleanPath(h.Name), h.FileInfo().Mode(), data })
+    case tar.TypeSymlink:
+      ms = append(ms, Member{
+        cleanPath(h.Name), h.FileInfo().Mode(), []byte(h.Linkname),
+      })
+    case tar.TypeDir:
+      // Directories are implied by the paths of their files.
+    case tar.TypeXGlobalHeader, tar.TypeXHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+      // These only hold metadata for the archive or the next member, as
+      // with the pax_global_header that git archive writes.
+    default:
+      return nil, fmt.Errorf("%w: %s has type %q", ErrUnsupported, h.Name, h.Typeflag)
+    }
+  }
+}
+
+func zipMembers(raw []byte) ([]Member, error) {
+  r, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+  if err != nil {
+    return nil, err
+  }
+
+  ms := []Member{}
+  left := MaxSize
+  for _, f := range r.File {
+    if f.FileInfo().IsDir() {
+      continue
+    }
+    rc, err := f.Open()
+    if err != nil {
+      return nil, err
+    }
+    data, err := readAll(rc, left)
+    rc.Close()
+    if err != nil {
+      return nil, fmt.Errorf("%s: %w", f.Name, err)
+    }
+    left -= int64(len(data))
+    ms = append(ms, Member{ cleanPath(f.Name), f.Mode(), data })
+  }
+  return ms, nil
+}
+
+// cleanPath strips leading slashes and "./" so that members are always
+// relative to the root of the archive.
+func cleanPath(name string) string {
+  return strings.TrimPrefix(path.Clean("/" + name), "/")
+}
@@ -0,0 +1,204 @@
+package archive_test
+
+import(
+  "testing"
+  "github.com/pjrebsch/mizudiff/archive"
+  "github.com/pjrebsch/mizudiff/tree"
+  "archive/tar"
+  "archive/zip"
+  "bytes"
+  "compress/gzip"
+  "errors"
+)
+
+type file struct {
+  name string
+  data string
+}
+
+func makeTar(t *testing.T, files []file) []byte {
+  var buf bytes.Buffer
+  w := tar.NewWriter(&buf)
+  w.WriteHeader(&tar.Header{ Name: "./src/", Typeflag: tar.TypeDir, Mode: 0755 })
+  for _, f := range files {
+    h := &tar.Header{ Name: f.name, Mode: 0644, Size: int64(len(f.data)) }
+    if err := w.WriteHeader(h); err != nil {
+      t.Fatal(err)
+    }
+    w.Write([]byte(f.data))
+  }
+  w.Close()
+  return buf.Bytes()
+}
+
+func makeZip(t *testing.T, files []file) []byte {
+  var buf bytes.Buffer
+  w := zip.NewWriter(&buf)
+  for _, f := range files {
+    fw, err := w.Create(f.name)
+    if err != nil {
+      t.Fatal(err)
+    }
+    fw.Write([]byte(f.data))
+  }
+  w.Close()
+  return buf.Bytes()
+}
+
+func gzipped(raw []byte) []byte {
+  var buf bytes.Buffer
+  w := gzip.NewWriter(&buf)
+  w.Write(raw)
+  w.Close()
+  return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+  files := []file{ {"a", "alpha"} }
+
+  var tbl = []struct {
+    raw []byte
+    r archive.Format
+  }{
+    { []byte{}, archive.Plain },
+    { []byte("hello"), archive.Plain },
+    { gzipped([]byte("hello")), archive.Gzip },
+    { makeTar(t, files), archive.Tar },
+    { makeZip(t, files), archive.Zip },
+    { makeZip(t, nil), archive.Zip },
+  }
+  for i, e := range tbl {
+    if actual := archive.Detect(e.raw); actual != e.r {
+      t.Errorf("Detect(#%d): expected %v, got %v", i, e.r, actual)
+    }
+  }
+}
+
+func TestMembers(t *testing.T) {
+  files := []file{ {"./src/foo.c", "int foo;"}, {"/README", "read me"} }
+
+  var tbl = []struct {
+    name string
+    raw []byte
+    paths []string
+  }{
+    { "plain", []byte("data"), []string{ "plain" } },
+    { "plain.gz", gzipped([]byte("data")), []string{ "plain" } },
+    { "a.tar", makeTar(t, files), []string{ "src/foo.c", "README" } },
+    { "a.tar.gz", gzipped(makeTar(t, files)), []string{ "src/foo.c", "README" } },
+    { "a.zip", makeZip(t, files), []string{ "src/foo.c", "README" } },
+  }
+  for _, e := range tbl {
+    ms, err := archive.Members(e.raw, e.name)
+    if err != nil {
+      t.Fatalf("Members(%s): did not expect an error, but got one: %v", e.name, err)
+    }
+    if len(ms) != len(e.paths) {
+      t.Fatalf("Members(%s): expected %d members, got %d", e.name, len(e.paths), len(ms))
+    }
+    for i, m := range ms {
+      if m.Path != e.paths[i] {
+        t.Errorf("Members(%s): expected path %s, got %s", e.name, e.paths[i], m.Path)
+      }
+    }
+  }
+
+  t.Run("rejects corrupt gzip data", func(t *testing.T) {
+    raw := gzipped([]byte("data"))[:12]
+    if _, err := archive.Members(raw, "x.gz"); err == nil {
+      t.Errorf("Members(): expected an error, but didn't get one")
+    }
+  })
+
+  t.Run("rejects members over the limit", func(t *testing.T) {
+    defer func(max int64) { archive.MaxSize = max }(archive.MaxSize)
+    archive.MaxSize = 4
+
+    var tbl = []struct {
+      name string
+      raw []byte
+    }{
+      { "x.gz", gzipped([]byte("hello")) },
+      { "a.tar", makeTar(t, []file{ {"a", "hello"} }) },
+      { "a.zip", makeZip(t, []file{ {"a", "hello"} }) },
+    }
+    for _, e := range tbl {
+      if _, err := archive.Members(e.raw, e.name); !errors.Is(err, archive.ErrTooLarge) {
+        t.Errorf("Members(%s): expected %v, got %v", e.name, archive.ErrTooLarge, err)
+      }
+    }
+    if _, err := archive.Members(makeZip(t, []file{ {"a", "four"} }), "a.zip"); err != nil {
+      t.Errorf("Members(): did not expect an error, but got one: %v", err)
+    }
+  })
+
+  t.Run("limits the members in total", func(t *testing.T) {
+    defer func(max int64) { archive.MaxSize = max }(archive.MaxSize)
+    archive.MaxSize = 6
+
+    files := []file{ {"a", "four"}, {"b", "four"} }
+    for name, raw := range map[string][]byte{
+      "a.tar": makeTar(t, files),
+      "a.zip": makeZip(t, files),
+    } {
+      if _, err := archive.Members(raw, name); !errors.Is(err, archive.ErrTooLarge) {
+        t.Errorf("Members(%s): expected %v, got %v", name, archive.ErrTooLarge, err)
+      }
+    }
+  })
+
+  t.Run("skips pax global headers", func(t *testing.T) {
+    // git archive begins every tarball with one of these.
+    var buf bytes.Buffer
+    w := tar.NewWriter(&buf)
+    w.WriteHeader(&tar.Header{
+      Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader,
+      PAXRecords: map[string]string{ "comment": "0123456789abcdef" },
+    })
+    w.WriteHeader(&tar.Header{ Name: "README", Mode: 0644, Size: 7 })
+    w.Write([]byte("read me"))
+    w.Close()
+
+    for _, raw := range [][]byte{ buf.Bytes(), gzipped(buf.Bytes()) } {
+      ms, err := archive.Members(raw, "release.tar.gz")
+      if err != nil {
+        t.Fatalf("Members(): did not expect an error, but got one: %v", err)
+      }
+      if len(ms) != 1 || ms[0].Path != "README" {
+        t.Errorf("Members(): expected only README, got %v", ms)
+      }
+    }
+  })
+
+  t.Run("rejects hard links", func(t *testing.T) {
+    var buf bytes.Buffer
+    w := tar.NewWriter(&buf)
+    w.WriteHeader(&tar.Header{ Name: "a", Typeflag: tar.TypeLink, Linkname: "b" })
+    w.Close()
+    if _, err := archive.Members(buf.Bytes(), "a.tar"); !errors.Is(err, archive.ErrUnsupported) {
+      t.Errorf("Members(): expected %v, got %v", archive.ErrUnsupported, err)
+    }
+  })
+}
+
+func TestTree(t *testing.T) {
+  a := gzipped(makeTar(t, []file{ {"src/foo.c", "0123456789abcdef0123456789"}, {"same", "same"} }))
+  b := gzipped(makeTar(t, []file{ {"src/foo.c", "0123456789abcdeX0123456789"}, {"same", "same"} }))
+
+  ta, err := archive.Tree(a, "a.tar.gz")
+  if err != nil {
+    t.Fatalf("Tree(): did not expect an error, but got one: %v", err)
+  }
+  tb, _ := archive.Tree(b, "b.tar.gz")
+
+  cs, err := tree.Compare(ta, tb)
+  if err != nil {
+    t.Fatalf("Compare(): did not expect an error, but got one: %v", err)
+  }
+  if len(cs) != 1 || cs[0].Path != "src/foo.c" {
+    t.Fatalf("Compare(): expected only src/foo.c to change, got %v", cs)
+  }
+  if r := cs[0].Regions; len(r) != 1 || r[0].Offset > 15 || r[0].End() <= 15 {
+    t.Errorf("Compare(): expected a region covering byte 15, got %v", r)
+  }
+}
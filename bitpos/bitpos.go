@@ -1,7 +1,7 @@
 package bitpos
 
 import (
-  "errors"
+  "fmt"
   "math"
   "math/big"
 )
@@ -84,11 +84,11 @@ func (p BitPosition) MultipliedBy(other BitPosition) BitPosition {
 // used for determining the correct byte slice size for a given bit string.
 func (p BitPosition) CeilByteOffset() (int64, error) {
   if p.Cmp(big.NewInt(math.MaxInt64)) >= 0 {
-    err := errors.New("reciever is greater than or equal to the max possible byte offset")
+    err := fmt.Errorf("%w: receiver is greater than or equal to the max possible byte offset", ErrOverflow)
     return 0, err
   }
   if p.Cmp(big.NewInt(math.MinInt64)) <= 0 {
-    err := errors.New("reciever is less than or equal to the min possible byte offset")
+    err := fmt.Errorf("%w: receiver is less than or equal to the min possible byte offset", ErrOverflow)
     return 0, err
   }
 
@@ -1,6 +1,7 @@
 package bitpos_test
 
 import (
+  "errors"
   "testing"
   "github.com/pjrebsch/mizudiff/bitpos"
   "math"
@@ -323,10 +324,10 @@ func TestCeilByteOffset(t *testing.T) {
       x := bitpos.New(e.x1, e.x2)
       _, err := x.CeilByteOffset()
 
-      if err == nil {
+      if !errors.Is(err, bitpos.ErrOverflow) {
         t.Errorf(
-          "%d.CeilByteOffset(): expected an error, but didn't get one",
-          x,
+          "%d.CeilByteOffset(): expected %v, but got %v",
+          x, bitpos.ErrOverflow, err,
         )
       }
     }
@@ -364,3 +365,39 @@ func TestCeilByteOffset(t *testing.T) {
     }
   }
 }
+
+// sink keeps the compiler from optimizing away the work of benchmarks.
+var sink bitpos.BitPosition
+
+func BenchmarkArithmetic(b *testing.B) {
+  // Small positions fit in a machine word; large ones don't.
+  var tbl = []struct {
+    name string
+    p, q bitpos.BitPosition
+  }{
+    { "small", bitpos.New(1234, 5), bitpos.New(0, 8) },
+    { "large", bitpos.New(math.MaxInt64, 7), bitpos.New(0, 256) },
+  }
+  var ops = []struct {
+    name string
+    f func(p, q bitpos.BitPosition) bitpos.BitPosition
+  }{
+    { "New", func(p, q bitpos.BitPosition) bitpos.BitPosition { return bitpos.New(p.Int64(), 7) } },
+    { "Plus", bitpos.BitPosition.Plus },
+    { "Minus", bitpos.BitPosition.Minus },
+    { "DividedBy", bitpos.BitPosition.DividedBy },
+    { "CeilDividedBy", bitpos.BitPosition.CeilDividedBy },
+    { "MultipliedBy", bitpos.BitPosition.MultipliedBy },
+    { "ByteOffset", func(p, q bitpos.BitPosition) bitpos.BitPosition { return bitpos.New(p.ByteOffset(), 0) } },
+  }
+  for _, e := range tbl {
+    for _, op := range ops {
+      b.Run(op.name + "/" + e.name, func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+          sink = op.f(e.p, e.q)
+        }
+      })
+    }
+  }
+}
@@ -0,0 +1,9 @@
+package bitpos
+
+import (
+  "errors"
+)
+
+// ErrOverflow is returned when a bit position can't be represented in the
+// integer type that was asked for.
+var ErrOverflow = errors.New("bit position overflows int64")
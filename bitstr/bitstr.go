@@ -2,14 +2,33 @@ package bitstr
 
 import (
   "github.com/pjrebsch/mizudiff/bitpos"
-  "errors"
+  "context"
+  "fmt"
   "math"
+  "math/bits"
   "bytes"
 )
 
+// Order is the order of the bits within each byte of a bit string.
+type Order int
+
+const (
+  MSBFirst Order = iota  // the most significant bit of each byte comes first
+  LSBFirst               // the least significant bit of each byte comes first
+)
+
+func (o Order) String() string {
+  if o == LSBFirst {
+    return "lsb"
+  }
+  return "msb"
+}
+
 type BitString struct {
-  bytes []byte  // raw data
+  bytes []byte  // raw data, always MSB-first
   length bitpos.BitPosition  // bit length of the string
+  shared bool  // whether `bytes` belongs to the caller of Wrap
+  order Order  // the order of the bits in the bytes given and returned
 }
 
 func IsEqual(a, b BitString) bool {
@@ -20,25 +39,96 @@ func New(bytes []byte) BitString {
   l := bitpos.New(int64(len(bytes)), 0)
   b := make([]byte, uint64(l.ByteOffset()))
   copy(b, bytes)
-  return BitString{ b, l }
+  return BitString{ bytes: b, length: l }
+}
+
+// NewOrder is New for bytes whose bits are in the given order. Every
+// operation works on the bits in that order, and bit strings made from it
+// keep it.
+func NewOrder(bytes []byte, o Order) BitString {
+  s := New(bytes)
+  if o == LSBFirst {
+    reverseBits(s.bytes)
+    s.order = o
+  }
+  return s
+}
+
+// Wrap is New without the copy: the bit string reads `b` directly, so `b`
+// must not be modified while the bit string is in use. The bit string never
+// writes to `b`, which may be read-only memory.
+func Wrap(b []byte) BitString {
+  l := bitpos.New(int64(len(b)), 0)
+  return BitString{ bytes: b, length: l, shared: true }
 }
 
+// WrapOrder is Wrap for bytes whose bits are in the given order. Bytes that
+// are LSB-first have to be reordered, so they are copied as with NewOrder.
+func WrapOrder(b []byte, o Order) BitString {
+  if o == LSBFirst {
+    return NewOrder(b, o)
+  }
+  return Wrap(b)
+}
+
+// Bytes returns the bits of the string in its order, padded with zero bits
+// to a whole byte.
 func (s BitString) Bytes() []byte {
   b := make([]byte, len(s.bytes))
   copy(b, s.bytes)
+  if s.order == LSBFirst {
+    reverseBits(b)
+  }
   return b
 }
 
+// Order returns the order of the bits within the string's bytes.
+func (s BitString) Order() Order {
+  return s.order
+}
+
+// WithOrder returns the same bits with the given order, so that Bytes packs
+// them into bytes that way.
+func (s BitString) WithOrder(o Order) BitString {
+  s.order = o
+  return s
+}
+
 func (s BitString) Length() bitpos.BitPosition {
   return s.length
 }
 
+// Bit reports whether the bit at position p is set.
+func (s BitString) Bit(p bitpos.BitPosition) (bool, error) {
+  if p.Sign() == -1 || p.Cmp(s.length.Int) >= 0 {
+    return false, ErrOutOfRange
+  }
+  mask := byte(0x1) << (bitpos.C - uint8(p.BitOffset()) - 1)
+  return s.bytes[p.ByteOffset()] & mask != 0, nil
+}
+
+// Count returns the number of set bits.
+func (s BitString) Count() int64 {
+  n := 0
+  // Bits past the length are always zero, so whole bytes can be counted.
+  for _, b := range s.bytes {
+    n += bits.OnesCount8(b)
+  }
+  return int64(n)
+}
+
 func (s *BitString) SetLength(p bitpos.BitPosition) error {
   if p.Sign() == -1 {
-    return errors.New("length cannot be negative")
+    return ErrNegativeLength
   }
   s.length = p
 
+  // Trimming the last byte writes to it, so take a copy first.
+  if s.shared {
+    s.bytes = append([]byte(nil), s.bytes...)
+    s.shared = false
+  }
+
   err := s.updateDataSize()
   if err != nil {
     return err
@@ -50,7 +140,7 @@ func (s *BitString) SetLength(p bitpos.BitPosition) error {
 
 func (s BitString) Slice(from, length bitpos.BitPosition) (BitString, error) {
   if length.Sign() == -1 {
-    return BitString{}, errors.New("length can't be less than zero")
+    return BitString{}, ErrNegativeLength
   }
 
   l, err := length.CeilByteOffset()
@@ -78,7 +168,9 @@ func (s BitString) Slice(from, length bitpos.BitPosition) (BitString, error) {
     byteOff = uint64(from.ByteOffset())
   }
 
-  for ; bufOff.Cmp(length.Int) == -1; byteOff += 1 {
+  // Fill whole bytes of the buffer. Comparing bit positions with `length`
+  // would stop early when `from` isn't byte aligned.
+  for ; bufOff.ByteOffset() < l; byteOff += 1 {
     thisPart, savedPart := byte(0x00), byte(0x00)
 
     if j := byteOff; j >= 0 && j < bytesLen {
@@ -107,32 +199,55 @@ func (s BitString) Slice(from, length bitpos.BitPosition) (BitString, error) {
   }
 
   out := New(buf)
+  out.order = s.order
   out.SetLength(length)
   return out, nil
 }
 
 // Shift performs a bitwise shift on the bit string.
-// A positive offset shifts right, negative offset shifts left.
+// A positive offset shifts right, negative offset shifts left, where right
+// is later in the string whatever its bit order.
 func (s BitString) Shift(offset bitpos.BitPosition) (BitString, error) {
   from := bitpos.Zero()
   from.Neg(offset.Int)
   return s.Slice(from, s.Length())
 }
 
+// Progress is told how many bytes of the source have been processed so far
+// out of the total.
+type Progress func(done, total int64)
+
+// progressStep is roughly how many source bytes are processed between calls
+// to a Progress.
+const progressStep = 1 << 20
+
 func (s BitString) XORCompress(adv, win uint16) (BitString, error) {
+  return s.XORCompressContext(context.Background(), adv, win, nil)
+}
+
+// XORCompressContext is XORCompress, but it stops with the context's error
+// when the context is done, checking between windows. If `progress` isn't
+// nil, it is called periodically while the windows are processed and once
+// when they all have been.
+func (s BitString) XORCompressContext(ctx context.Context, adv, win uint16, progress Progress) (BitString, error) {
   if adv == 0 {
-    return BitString{}, errors.New("advance rate must be greater than zero")
+    return BitString{},
+      fmt.Errorf("%w: advance rate must be greater than zero", ErrInvalidWindow)
   }
   if win == 0 {
-    return BitString{}, errors.New("window size must be greater than zero")
+    return BitString{},
+      fmt.Errorf("%w: window size must be greater than zero", ErrInvalidWindow)
   }
   if adv > win {
-    err := errors.New("advance rate can't be greater than window size")
+    err := fmt.Errorf("%w: advance rate can't be greater than window size", ErrInvalidWindow)
     return BitString{}, err
   }
 
   if len(s.bytes) == 0 {
-    return BitString{}, nil
+    if progress != nil {
+      progress(0, 0)
+    }
+    return New([]byte{}).WithOrder(s.order), nil
   }
 
   advRate := bitpos.New(0, int64(adv))
@@ -159,7 +274,28 @@ func (s BitString) XORCompress(adv, win uint16) (BitString, error) {
   // Bit index for `s.bytes`.
   j := bitpos.Zero()
 
+  done := ctx.Done()
+  total := int64(len(s.bytes))
+
+  // Count windows rather than reading `j` so that reporting progress stays
+  // cheap.
+  count := int64(0)
+  step := int64(progressStep * bitpos.C / int64(win))
+  if step == 0 {
+    step = 1
+  }
+
   for j.Cmp(s.Length().Int) == -1 {
+    select {
+    case <-done:
+      return BitString{}, ctx.Err()
+    default:
+    }
+    if progress != nil && count > 0 && count % step == 0 {
+      progress(count * int64(win) / bitpos.C, total)
+    }
+    count++
+
     slice, err := s.Slice(j, winSize)
     if err != nil {
       return BitString{}, err
@@ -194,7 +330,12 @@ func (s BitString) XORCompress(adv, win uint16) (BitString, error) {
     j = j.Plus(winSize)
   }
 
+  if progress != nil {
+    progress(total, total)
+  }
+
   r := New(out)
+  r.order = s.order
   r.SetLength(length)
   return r, nil
 }
@@ -210,7 +351,7 @@ func Diff(a, b BitString, w bitpos.BitPosition) (BitString, error) {
   s := BitString{}
 
   if w.Sign() < 1 {
-    return s, errors.New("window size must be greater than zero")
+    return s, fmt.Errorf("%w: window size must be greater than zero", ErrInvalidWindow)
   }
 
   minLength := bitpos.Min(a.Length(), b.Length())
@@ -247,6 +388,7 @@ func Diff(a, b BitString, w bitpos.BitPosition) (BitString, error) {
   }
 
   s = New(out)
+  s.order = a.order
   s.SetLength(outLength)
   return s, nil
 }
@@ -267,6 +409,13 @@ func Diff(a, b BitString, w bitpos.BitPosition) (BitString, error) {
 //   fmt.Printf("%s\n", bytestr)
 // }
 
+// reverseBits converts bytes between MSB-first and LSB-first in place.
+func reverseBits(b []byte) {
+  for i := range b {
+    b[i] = bits.Reverse8(b[i])
+  }
+}
+
 func (s *BitString) updateDataSize() error {
   n := int64(len(s.bytes))
   l, err := s.length.CeilByteOffset()
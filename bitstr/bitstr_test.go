@@ -1,6 +1,9 @@
 package bitstr_test
 
 import(
+  "context"
+  "errors"
+  "fmt"
   "testing"
   "github.com/pjrebsch/mizudiff/bitstr"
   "github.com/pjrebsch/mizudiff/bitpos"
@@ -68,6 +71,122 @@ func TestNew(t *testing.T) {
   })
 }
 
+func TestWrap(t *testing.T) {
+  t.Run("uses the original slice", func(t *testing.T) {
+    b := []byte{ 0xff }
+    s := bitstr.Wrap(b)
+
+    b[0] = byte(0x0f)
+
+    actual := s.Bytes()[0]
+    expected := byte(0x0f)
+
+    if actual != expected {
+      t.Errorf("Wrap(%02x): expected %02x, got %02x", b, expected, actual)
+    }
+  })
+
+  t.Run("doesn't write to the original slice", func(t *testing.T) {
+    b := []byte{ 0xff, 0xff }
+    s := bitstr.Wrap(b)
+
+    if err := s.SetLength(bitpos.New(1, 4)); err != nil {
+      t.Fatalf("SetLength(): did not expect an error, but got one: %v", err)
+    }
+
+    if !bytes.Equal(b, []byte{ 0xff, 0xff }) {
+      t.Errorf("SetLength(): expected the wrapped slice to be ffff, got %02x", b)
+    }
+    if !bytes.Equal(s.Bytes(), []byte{ 0xff, 0xf0 }) {
+      t.Errorf("SetLength(): expected fff0, got %02x", s.Bytes())
+    }
+  })
+}
+
+func TestNewOrder(t *testing.T) {
+  s := bitstr.NewOrder([]byte{ 0x01, 0x0f }, bitstr.LSBFirst)
+
+  if s.Order() != bitstr.LSBFirst {
+    t.Errorf("Order(): expected %v, got %v", bitstr.LSBFirst, s.Order())
+  }
+  for i, expected := range []bool{ true, false, false, false, false, false, false, false, true } {
+    actual, err := s.Bit(bitpos.New(0, int64(i)))
+    if err != nil {
+      t.Fatalf("Bit(%d): did not expect an error, but got one: %v", i, err)
+    }
+    if actual != expected {
+      t.Errorf("Bit(%d): expected %v, got %v", i, expected, actual)
+    }
+  }
+
+  // Bits past the length are cleared from the high end of an LSB-first
+  // byte.
+  s.SetLength(bitpos.New(1, 2))
+  if actual := s.Bytes(); !bytes.Equal(actual, []byte{ 0x01, 0x03 }) {
+    t.Errorf("Bytes(): expected 0103, got %02x", actual)
+  }
+}
+
+func TestWithOrder(t *testing.T) {
+  var tbl = []struct {
+    from, to bitstr.Order
+    in, out []byte
+  }{
+    { bitstr.MSBFirst, bitstr.LSBFirst, []byte{ 0x80, 0x0f }, []byte{ 0x01, 0xf0 } },
+    { bitstr.LSBFirst, bitstr.MSBFirst, []byte{ 0x80, 0x0f }, []byte{ 0x01, 0xf0 } },
+    { bitstr.LSBFirst, bitstr.LSBFirst, []byte{ 0x80, 0x0f }, []byte{ 0x80, 0x0f } },
+  }
+  for _, e := range tbl {
+    s := bitstr.NewOrder(e.in, e.from).WithOrder(e.to)
+    if s.Order() != e.to {
+      t.Errorf("WithOrder(%v): expected order %v, got %v", e.to, e.to, s.Order())
+    }
+    if actual := s.Bytes(); !bytes.Equal(actual, e.out) {
+      t.Errorf("WithOrder(%v) of %02x (%v): expected %02x, got %02x", e.to, e.in, e.from, e.out, actual)
+    }
+  }
+
+  t.Run("results keep the order", func(t *testing.T) {
+    s := bitstr.NewOrder([]byte{ 0x12, 0x34 }, bitstr.LSBFirst)
+    sliced, err := s.Slice(bitpos.New(0, 4), bitpos.New(1, 0))
+    if err != nil {
+      t.Fatalf("Slice(): did not expect an error, but got one: %v", err)
+    }
+    compressed, err := s.XORCompress(1, 8)
+    if err != nil {
+      t.Fatalf("XORCompress(): did not expect an error, but got one: %v", err)
+    }
+    diff, err := bitstr.Diff(s, s, bitpos.New(0, 8))
+    if err != nil {
+      t.Fatalf("Diff(): did not expect an error, but got one: %v", err)
+    }
+    for _, r := range []bitstr.BitString{ sliced, compressed, diff } {
+      if r.Order() != bitstr.LSBFirst {
+        t.Errorf("expected a result in %v, got %v", bitstr.LSBFirst, r.Order())
+      }
+    }
+  })
+}
+
+func TestWrapOrder(t *testing.T) {
+  in := []byte{ 0x01, 0x80 }
+
+  msb := bitstr.WrapOrder(in, bitstr.MSBFirst)
+  lsb := bitstr.WrapOrder(in, bitstr.LSBFirst)
+  if !bitstr.IsEqual(lsb, bitstr.NewOrder(in, bitstr.LSBFirst)) {
+    t.Errorf("WrapOrder(%v): expected the bits of NewOrder", bitstr.LSBFirst)
+  }
+
+  // MSB-first bytes are read in place, while LSB-first ones are copied.
+  in[0] = 0xff
+  if actual := msb.Bytes(); !bytes.Equal(actual, []byte{ 0xff, 0x80 }) {
+    t.Errorf("WrapOrder(%v): expected to read the given bytes, got %02x", bitstr.MSBFirst, actual)
+  }
+  if actual := lsb.Bytes(); !bytes.Equal(actual, []byte{ 0x01, 0x80 }) {
+    t.Errorf("WrapOrder(%v): expected a copy of the given bytes, got %02x", bitstr.LSBFirst, actual)
+  }
+}
+
 func TestBytes(t *testing.T) {
   for _, e := range tblConstructors {
     b := deterministicBytes(e.byteLen, e.strSeed)
@@ -133,6 +252,111 @@ func TestLength(t *testing.T) {
   })
 }
 
+func TestBit(t *testing.T) {
+  t.Run("position must be inside the bit string", func(t *testing.T) {
+    s := bitstr.New( []byte{ 0xff } )
+
+    for _, p := range []bitpos.BitPosition{ bitpos.New(0,-1), bitpos.New(1,0) } {
+      _, err := s.Bit(p)
+      if !errors.Is(err, bitstr.ErrOutOfRange) {
+        t.Errorf("Bit(%d): expected %v, but got %v", p, bitstr.ErrOutOfRange, err)
+      }
+    }
+  })
+
+  var tbl = []struct {
+    x1, x2 int64
+    in []byte
+    r bool
+  }{
+    {0,0, []byte{ 0x80 }, true},
+    {0,1, []byte{ 0x80 }, false},
+    {0,7, []byte{ 0x01 }, true},
+    {1,0, []byte{ 0x01, 0x7f }, false},
+    {1,1, []byte{ 0x01, 0x7f }, true},
+  }
+  for _, e := range tbl {
+    s := bitstr.New(e.in)
+    p := bitpos.New(e.x1, e.x2)
+
+    actual, err := s.Bit(p)
+    if err != nil {
+      t.Fatalf("Bit(%d): did not expect an error, but got one: %v", p, err)
+    }
+    if actual != e.r {
+      t.Errorf("Bit(%d) of %08b: expected %v, got %v", p, e.in, e.r, actual)
+    }
+  }
+}
+
+func TestCount(t *testing.T) {
+  var tbl = []struct {
+    in []byte
+    l int64
+    r int64
+  }{
+    { []byte{}, 0, 0 },
+    { []byte{ 0x00, 0x00 }, 16, 0 },
+    { []byte{ 0xff, 0x01 }, 16, 9 },
+    { []byte{ 0xff, 0xff }, 12, 12 },
+  }
+  for _, e := range tbl {
+    s := bitstr.New(e.in)
+    s.SetLength(bitpos.New(0, e.l))
+
+    if actual := s.Count(); actual != e.r {
+      t.Errorf("Count() of %08b: expected %d, got %d", s.Bytes(), e.r, actual)
+    }
+  }
+}
+
+func TestBitLSB(t *testing.T) {
+  var tbl = []struct {
+    x1, x2 int64
+    in []byte
+    r bool
+  }{
+    {0,0, []byte{ 0x01 }, true},
+    {0,1, []byte{ 0x01 }, false},
+    {0,7, []byte{ 0x80 }, true},
+    {1,0, []byte{ 0x80, 0xfe }, false},
+    {1,1, []byte{ 0x80, 0xfe }, true},
+  }
+  for _, e := range tbl {
+    s := bitstr.NewOrder(e.in, bitstr.LSBFirst)
+    p := bitpos.New(e.x1, e.x2)
+
+    actual, err := s.Bit(p)
+    if err != nil {
+      t.Fatalf("Bit(%d): did not expect an error, but got one: %v", p, err)
+    }
+    if actual != e.r {
+      t.Errorf("Bit(%d) of %08b: expected %v, got %v", p, e.in, e.r, actual)
+    }
+  }
+}
+
+func TestCountLSB(t *testing.T) {
+  var tbl = []struct {
+    in []byte
+    l int64
+    r int64
+  }{
+    { []byte{ 0x00, 0x00 }, 16, 0 },
+    { []byte{ 0xff, 0x80 }, 16, 9 },
+    { []byte{ 0xff, 0x80 }, 12, 8 },
+    { []byte{ 0xff, 0xff }, 12, 12 },
+  }
+  for _, e := range tbl {
+    s := bitstr.NewOrder(e.in, bitstr.LSBFirst)
+    s.SetLength(bitpos.New(0, e.l))
+
+    if actual := s.Count(); actual != e.r {
+      t.Errorf("Count() of %08b: expected %d, got %d", s.Bytes(), e.r, actual)
+    }
+  }
+}
+
 func TestSetLength(t *testing.T) {
   var tblSetLength = []struct {
     byteOffset, bitOffset int64
@@ -207,10 +431,10 @@ func TestSlice(t *testing.T) {
     length := bitpos.New(0,-1)
 
     _, err := s.Slice(from, length)
-    if err == nil {
+    if !errors.Is(err, bitstr.ErrNegativeLength) {
       t.Errorf(
-        "Slice(%d, %d): expected an error, but didn't get one",
-        from, length,
+        "Slice(%d, %d): expected %v, but got %v",
+        from, length, bitstr.ErrNegativeLength, err,
       )
     }
   })
@@ -260,6 +484,45 @@ func TestSlice(t *testing.T) {
   }
 }
 
+func TestSliceLSB(t *testing.T) {
+  var tbl = []struct {
+    f1, f2 int64
+    l1, l2 int64
+    in, out []byte
+  }{
+    {0,0, 0,1, []byte{ 0xff }, []byte{ 0x01 }},
+    {0,6, 1,1, []byte{ 0xff }, []byte{ 0x03, 0x00 }},
+    {0,-1, 0,3, []byte{ 0xff }, []byte{ 0x06 }},
+    {0,-3, 1,0, []byte{ 0xaa }, []byte{ 0x50 }},
+    {0,-3, 2,0, []byte{ 0x66 }, []byte{ 0x30, 0x03 }},
+    {1,0, 2,0, []byte{ 0xff, 0xff }, []byte{ 0xff, 0x00 }},
+    {
+      -2,-2,  4,7,
+      []byte{ 0x4b, 0xfd, 0x1e, 0x75 },  // 11010010 10111111 01111000 10101110
+      []byte{ 0x00, 0x00, 0x2c, 0xf5, 0x7b },  // 00000000 00000000 00110100 10101111 11011110
+    },
+  }
+  for _, e := range tbl {
+    s := bitstr.NewOrder(e.in, bitstr.LSBFirst)
+    from := bitpos.New(e.f1,e.f2)
+    length := bitpos.New(e.l1,e.l2)
+
+    result, err := s.Slice(from, length)
+    if err != nil {
+      t.Fatalf(
+        "Slice(%d, %d): errored: %v",
+        from, length, err,
+      )
+    }
+    if !bytes.Equal(result.Bytes(), e.out) {
+      t.Errorf(
+        "Slice(%d, %d): expected %08b, got %08b",
+        from, length, e.out, result.Bytes(),
+      )
+    }
+  }
+}
+
 func TestShift(t *testing.T) {
   var tbl = []struct {
     x1, x2 int64
@@ -300,6 +563,42 @@ func TestShift(t *testing.T) {
   }
 }
 
+func TestShiftLSB(t *testing.T) {
+  var tbl = []struct {
+    x1, x2 int64
+    in, out []byte
+  }{
+    {0,1, []byte{ 0xff, 0xff }, []byte{ 0xfe, 0xff }},
+    {0,4, []byte{ 0xff, 0xff }, []byte{ 0xf0, 0xff }},
+    {1,0, []byte{ 0xff, 0xff }, []byte{ 0x00, 0xff }},
+    {0,-1, []byte{ 0xff, 0xff }, []byte{ 0xff, 0x7f }},
+    {0,-4, []byte{ 0xff, 0xff }, []byte{ 0xff, 0x0f }},
+    {-1,0, []byte{ 0xff, 0xff }, []byte{ 0xff, 0x00 }},
+  }
+  for _, e := range tbl {
+    a := bitstr.NewOrder(e.in, bitstr.LSBFirst)
+    off := bitpos.New( e.x1, e.x2 )
+
+    b, err := a.Shift(off)
+    if err != nil {
+      t.Fatalf(
+        "Shift(%d): did not expect an error, but got one: %v",
+        off, err,
+      )
+    }
+
+    actual := b.Bytes()
+    expected := e.out
+
+    if !bytes.Equal(actual, expected) {
+      t.Errorf(
+        "Shift(%d): expected %08b, got %08b",
+        off, expected, actual,
+      )
+    }
+  }
+}
+
 func TestXORCompress(t *testing.T) {
   t.Run("window size must be greater than zero", func(t *testing.T) {
     s := bitstr.New( []byte{} )
@@ -307,10 +606,10 @@ func TestXORCompress(t *testing.T) {
     win := uint16(1)
 
     _, err := s.XORCompress(adv, win)
-    if err == nil {
+    if !errors.Is(err, bitstr.ErrInvalidWindow) {
       t.Errorf(
-        "XORCompress(%d, %d): expected an error, but didn't get one",
-        adv, win,
+        "XORCompress(%d, %d): expected %v, but got %v",
+        adv, win, bitstr.ErrInvalidWindow, err,
       )
     }
   })
@@ -320,10 +619,10 @@ func TestXORCompress(t *testing.T) {
     win := uint16(0)
 
     _, err := s.XORCompress(adv, win)
-    if err == nil {
+    if !errors.Is(err, bitstr.ErrInvalidWindow) {
       t.Errorf(
-        "XORCompress(%d, %d): expected an error, but didn't get one",
-        adv, win,
+        "XORCompress(%d, %d): expected %v, but got %v",
+        adv, win, bitstr.ErrInvalidWindow, err,
       )
     }
   })
@@ -333,10 +632,10 @@ func TestXORCompress(t *testing.T) {
     win := uint16(1)
 
     _, err := s.XORCompress(adv, win)
-    if err == nil {
+    if !errors.Is(err, bitstr.ErrInvalidWindow) {
       t.Errorf(
-        "XORCompress(%d, %d): expected an error, but didn't get one",
-        adv, win,
+        "XORCompress(%d, %d): expected %v, but got %v",
+        adv, win, bitstr.ErrInvalidWindow, err,
       )
     }
   })
@@ -406,6 +705,95 @@ func TestXORCompress(t *testing.T) {
   }
 }
 
+func TestXORCompressLSB(t *testing.T) {
+  var tbl = []struct {
+    in, out []byte
+    advanceRate, windowSize uint16
+  }{
+    {
+      []byte{0x1f},
+      []byte{0x1f},
+      1, 8,
+    }, {
+      // 11111000  (0x1f)
+      //  10000000  (0x01)
+      // 101110000
+      []byte{0x1f, 0x01},
+      []byte{0x1d, 0x00},
+      1, 8,
+    }, {
+      []byte{0x1f, 0x35, 0x12, 0x76, 0xf0, 0x5b, 0x19, 0x96, 0x3c, 0xac},
+      []byte{0xad, 0x2e, 0x01},
+      1, 8,
+    },
+  }
+
+  for _, e := range tbl {
+    s, err := bitstr.NewOrder(e.in, bitstr.LSBFirst).XORCompress( e.advanceRate, e.windowSize )
+    if err != nil {
+      t.Fatalf(
+        "XORCompress(%d, %d): did not expect an error, but got one: %v",
+        e.advanceRate, e.windowSize, err,
+      )
+    }
+
+    actual := s.Bytes()
+    expected := e.out
+
+    if !bytes.Equal(actual, expected) {
+      t.Errorf(
+        "XORCompress(%d, %d): expected %08b, got %08b",
+        e.advanceRate, e.windowSize, expected, actual,
+      )
+    }
+  }
+}
+
+func TestXORCompressContext(t *testing.T) {
+  t.Run("stops when the context is canceled", func(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    s := bitstr.New(deterministicBytes(64, 0))
+    _, err := s.XORCompressContext(ctx, 1, 8, nil)
+    if !errors.Is(err, context.Canceled) {
+      t.Errorf("XORCompressContext: expected %v, but got %v", context.Canceled, err)
+    }
+  })
+
+  t.Run("reports progress up to the total", func(t *testing.T) {
+    // Big enough to span a few progress steps. Wide windows keep it quick.
+    in := deterministicBytes(3 << 20, 0)
+    s := bitstr.New(in)
+
+    last := int64(-1)
+    calls := 0
+    progress := func(done, total int64) {
+      calls++
+      if total != int64(len(in)) {
+        t.Errorf("progress: expected a total of %d, got %d", len(in), total)
+      }
+      if done <= last || done > total {
+        t.Errorf("progress: %d follows %d out of %d", done, last, total)
+      }
+      last = done
+    }
+
+    actual, err := s.XORCompressContext(context.Background(), 64, 256, progress)
+    if err != nil {
+      t.Fatalf("XORCompressContext: did not expect an error, but got one: %v", err)
+    }
+    if calls < 2 || last != int64(len(in)) {
+      t.Errorf("progress: called %d times, last with %d", calls, last)
+    }
+
+    expected, _ := s.XORCompress(64, 256)
+    if !bitstr.IsEqual(actual, expected) {
+      t.Errorf("XORCompressContext: output differs from XORCompress")
+    }
+  })
+}
+
 func TestDiff(t *testing.T) {
   var tbl = []struct {
     w1, w2 int64
@@ -452,6 +840,188 @@ func TestDiff(t *testing.T) {
   }
 }
 
+func TestDiffLSB(t *testing.T) {
+  var tbl = []struct {
+    w1, w2 int64
+    a, b, r []byte
+  }{
+    { 0,8, []byte{0x00,0x00}, []byte{0x00,0x88}, []byte{0x02} },
+    {
+      0,7,
+      []byte{0x15,0xd8}, // 1010100 0000110 11
+      []byte{0x4c},      // 0011001 0
+      []byte{0x03},      // 11
+    }, {
+      0,3,
+      []byte{0x15,0xd8}, // 101 010 000 001 101 1
+      []byte{0x2d,0x5e}, // 101 101 000 111 101 0
+      []byte{0x2a}, // 010101
+    },
+  }
+  for _, e := range tbl {
+    w := bitpos.New(e.w1, e.w2)
+    a := bitstr.NewOrder(e.a, bitstr.LSBFirst)
+    b := bitstr.NewOrder(e.b, bitstr.LSBFirst)
+
+    d, err := bitstr.Diff(a, b, w)
+    if err != nil {
+      t.Fatalf(
+        "Diff(%08b, %08b, %d): did not expect an error but got one: %#v",
+        a.Bytes(), b.Bytes(), w, err.Error(),
+      )
+    }
+
+    actual := d.Bytes()
+    expected := e.r
+
+    if !bytes.Equal(actual, expected) {
+      t.Errorf(
+        "Diff(%08b, %08b, %d): expected %08b, got %08b",
+        a.Bytes(), b.Bytes(), w, expected, actual,
+      )
+    }
+  }
+}
+
+func FuzzSlice(f *testing.F) {
+  f.Add([]byte{0xa8, 0x1b}, int64(3), int64(9))
+  f.Add([]byte{0xff}, int64(-5), int64(12))
+  f.Add([]byte{}, int64(0), int64(0))
+
+  f.Fuzz(func(t *testing.T, in []byte, from, length int64) {
+    if from < -1 << 16 || from > 1 << 16 || length < 0 || length > 1 << 12 {
+      t.Skip()
+    }
+    s := bitstr.New(in)
+    out, err := s.Slice(bitpos.New(0, from), bitpos.New(0, length))
+    if err != nil {
+      t.Fatalf("Slice(%d, %d): did not expect an error, but got one: %v", from, length, err)
+    }
+    if l := out.Length().Int64(); l != length {
+      t.Fatalf("Slice(%d, %d): expected a length of %d, got %d", from, length, length, l)
+    }
+    if n := int64(len(out.Bytes())); n != (length + 7) / 8 {
+      t.Fatalf("Slice(%d, %d): expected %d bytes, got %d", from, length, (length + 7) / 8, n)
+    }
+
+    for i := int64(0); i < length; i++ {
+      expected := false
+      if p := from + i; p >= 0 && p < int64(len(in)) * 8 {
+        expected, _ = s.Bit(bitpos.New(0, p))
+      }
+      actual, _ := out.Bit(bitpos.New(0, i))
+      if actual != expected {
+        t.Fatalf("Slice(%d, %d): bit %d should be %v", from, length, i, expected)
+      }
+    }
+  })
+}
+
+func FuzzShift(f *testing.F) {
+  f.Add([]byte{0xa8, 0x1b}, int64(3))
+  f.Add([]byte{0xa8, 0x1b}, int64(-11))
+
+  f.Fuzz(func(t *testing.T, in []byte, offset int64) {
+    if offset < -1 << 16 || offset > 1 << 16 {
+      t.Skip()
+    }
+    s := bitstr.New(in)
+    out, err := s.Shift(bitpos.New(0, offset))
+    if err != nil {
+      t.Fatalf("Shift(%d): did not expect an error, but got one: %v", offset, err)
+    }
+    if !bitpos.IsEqual(out.Length(), s.Length()) {
+      t.Fatalf("Shift(%d): expected a length of %v, got %v", offset, s.Length(), out.Length())
+    }
+
+    // Shifting back restores whatever wasn't shifted out.
+    back, err := out.Shift(bitpos.New(0, -offset))
+    if err != nil {
+      t.Fatalf("Shift(%d): did not expect an error, but got one: %v", -offset, err)
+    }
+    n := s.Length().Int64()
+    for i := int64(0); i < n; i++ {
+      kept := i + offset >= 0 && i + offset < n
+      expected, _ := s.Bit(bitpos.New(0, i))
+      actual, _ := back.Bit(bitpos.New(0, i))
+      if kept && actual != expected || !kept && actual {
+        t.Fatalf("Shift(%d): bit %d didn't survive shifting back", offset, i)
+      }
+    }
+  })
+}
+
+func FuzzXORCompress(f *testing.F) {
+  f.Add([]byte{0xf8, 0xac, 0x48, 0x6e, 0x0f}, uint16(1), uint16(8))
+  f.Add([]byte{0xf8, 0xac, 0x48}, uint16(3), uint16(5))
+
+  f.Fuzz(func(t *testing.T, in []byte, adv, win uint16) {
+    if win > 64 || len(in) > 1 << 10 {
+      t.Skip()
+    }
+    out, err := bitstr.New(in).XORCompress(adv, win)
+    if adv == 0 || win == 0 || adv > win {
+      if !errors.Is(err, bitstr.ErrInvalidWindow) {
+        t.Fatalf("XORCompress(%d, %d): expected %v, got %v", adv, win, bitstr.ErrInvalidWindow, err)
+      }
+      return
+    }
+    if err != nil {
+      t.Fatalf("XORCompress(%d, %d): did not expect an error, but got one: %v", adv, win, err)
+    }
+
+    // Every window but the first advances the output by `adv` bits.
+    expected := int64(0)
+    if n := int64(len(in)) * 8; n > 0 {
+      windows := (n + int64(win) - 1) / int64(win)
+      expected = (windows - 1) * int64(adv) + int64(win)
+    }
+    if l := out.Length().Int64(); l != expected {
+      t.Fatalf("XORCompress(%d, %d): expected a length of %d, got %d", adv, win, expected, l)
+    }
+  })
+}
+
+func FuzzDiff(f *testing.F) {
+  f.Add([]byte{0xa8, 0x1b}, []byte{0xb4, 0x7a}, int64(3))
+  f.Add([]byte{0x00}, []byte{}, int64(8))
+
+  f.Fuzz(func(t *testing.T, a, b []byte, w int64) {
+    if w < 1 || w > 64 {
+      t.Skip()
+    }
+    sa, sb := bitstr.New(a), bitstr.New(b)
+    d, err := bitstr.Diff(sa, sb, bitpos.New(0, w))
+    if err != nil {
+      t.Fatalf("Diff(%d): did not expect an error, but got one: %v", w, err)
+    }
+
+    n := int64(len(a))
+    if int64(len(b)) < n {
+      n = int64(len(b))
+    }
+    windows := (n * 8 + w - 1) / w
+    if l := d.Length().Int64(); l != windows {
+      t.Fatalf("Diff(%d): expected a length of %d, got %d", w, windows, l)
+    }
+
+    // Diffing a string with itself finds nothing.
+    self, _ := bitstr.Diff(sa, sa, bitpos.New(0, w))
+    if self.Count() != 0 {
+      t.Fatalf("Diff(%d): expected a string to have no differences with itself", w)
+    }
+
+    for j := int64(0); j < windows; j++ {
+      x, _ := sa.Slice(bitpos.New(0, j * w), bitpos.New(0, w))
+      y, _ := sb.Slice(bitpos.New(0, j * w), bitpos.New(0, w))
+      set, _ := d.Bit(bitpos.New(0, j))
+      if set == bitstr.IsEqual(x, y) {
+        t.Fatalf("Diff(%d): window %d should be set: %v", w, j, !set)
+      }
+    }
+  })
+}
+
 func deterministicBytes(length int, seed int64) []byte {
   src := rand.NewSource(seed)
   r := rand.New(src)
@@ -464,3 +1034,84 @@ func deterministicBytes(length int, seed int64) []byte {
 
   return str
 }
+
+// benchSizes are the lengths in bytes of the bit strings that benchmarks
+// work on.
+var benchSizes = []int{ 1 << 10, 1 << 14, 1 << 18 }
+
+// benchConfigs are the (adv, win) settings that benchmarks compress with.
+var benchConfigs = []struct {
+  adv, win uint16
+}{
+  { 1, 8 },
+  { 4, 32 },
+  { 64, 256 },
+}
+
+// benchSink keeps the compiler from optimizing away the work of benchmarks.
+var benchSink bitstr.BitString
+
+func BenchmarkSlice(b *testing.B) {
+  for _, n := range benchSizes {
+    s := bitstr.New(deterministicBytes(n, int64(n)))
+    half := bitpos.New(int64(n / 2), 0)
+
+    // Byte-aligned slices can copy; others have to shift every byte.
+    for _, off := range []int64{ 0, 3 } {
+      b.Run(fmt.Sprintf("%d/offset=%d", n, off), func(b *testing.B) {
+        b.SetBytes(int64(n / 2))
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+          benchSink, _ = s.Slice(bitpos.New(0, off), half)
+        }
+      })
+    }
+  }
+}
+
+func BenchmarkShift(b *testing.B) {
+  for _, n := range benchSizes {
+    s := bitstr.New(deterministicBytes(n, int64(n)))
+    for _, off := range []int64{ 8, 3, -3 } {
+      b.Run(fmt.Sprintf("%d/offset=%d", n, off), func(b *testing.B) {
+        b.SetBytes(int64(n))
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+          benchSink, _ = s.Shift(bitpos.New(0, off))
+        }
+      })
+    }
+  }
+}
+
+func BenchmarkXORCompress(b *testing.B) {
+  for _, n := range benchSizes {
+    s := bitstr.New(deterministicBytes(n, int64(n)))
+    for _, c := range benchConfigs {
+      b.Run(fmt.Sprintf("%d/adv=%d,win=%d", n, c.adv, c.win), func(b *testing.B) {
+        b.SetBytes(int64(n))
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+          benchSink, _ = s.XORCompress(c.adv, c.win)
+        }
+      })
+    }
+  }
+}
+
+func BenchmarkDiff(b *testing.B) {
+  for _, n := range benchSizes {
+    x := bitstr.New(deterministicBytes(n, int64(n)))
+    y := bitstr.New(deterministicBytes(n, int64(n) + 1))
+    for _, c := range benchConfigs {
+      w := bitpos.New(0, int64(c.win))
+      b.Run(fmt.Sprintf("%d/win=%d", n, c.win), func(b *testing.B) {
+        b.SetBytes(int64(n))
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+          benchSink, _ = bitstr.Diff(x, y, w)
+        }
+      })
+    }
+  }
+}
@@ -0,0 +1,18 @@
+package bitstr
+
+import (
+  "errors"
+)
+
+var (
+  // ErrNegativeLength is returned when a length is less than zero.
+  ErrNegativeLength = errors.New("length can't be less than zero")
+
+  // ErrOutOfRange is returned when a position falls outside of the bit
+  // string.
+  ErrOutOfRange = errors.New("position is outside of the bit string")
+
+  // ErrInvalidWindow is returned when an advance rate or window size can't
+  // be used.
+  ErrInvalidWindow = errors.New("invalid window")
+)
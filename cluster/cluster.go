@@ -0,0 +1,172 @@
+package cluster
+
+import(
+  "github.com/pjrebsch/mizudiff/digest"
+  "errors"
+  "fmt"
+  "io"
+  "math"
+)
+
+// Matrix returns the distance between every pair of digests, as with
+// digest.Distance.
+func Matrix(ds []digest.Digest) ([][]float64, error) {
+  m := make([][]float64, len(ds))
+  for i := range m {
+    m[i] = make([]float64, len(ds))
+  }
+  for i := range ds {
+    for j := i + 1; j < len(ds); j++ {
+      d, err := digest.Distance(ds[i], ds[j])
+      if err != nil {
+        return nil, err
+      }
+      m[i][j], m[j][i] = d, d
+    }
+  }
+  return m, nil
+}
+
+// Linkage decides the distance between two clusters from the distances
+// between their members.
+type Linkage int
+
+const (
+  Average Linkage = iota  // the mean distance between members
+  Single                  // the distance between the closest members
+  Complete                // the distance between the furthest members
+)
+
+var linkageNames = map[Linkage]string {
+  Average: "average",
+  Single: "single",
+  Complete: "complete",
+}
+
+func (l Linkage) String() string {
+  if name, ok := linkageNames[l]; ok {
+    return name
+  }
+  return "unknown"
+}
+
+// ParseLinkage returns the linkage with the given name.
+func ParseLinkage(name string) (Linkage, error) {
+  for l, n := range linkageNames {
+    if n == name {
+      return l, nil
+    }
+  }
+  return Average, errors.New("linkage is not recognized: " + name)
+}
+
+// Node is a cluster in a dendrogram. A leaf stands for a single input and
+// has no children.
+type Node struct {
+  Leaf int  // the input's index, for a leaf
+  Left, Right *Node
+  Height float64  // the distance at which the children were joined
+  Size int  // how many inputs the cluster holds
+}
+
+func (n *Node) IsLeaf() bool {
+  return n.Left == nil
+}
+
+// Leaves returns the indexes of the inputs in the cluster, in the order
+// they appear in the dendrogram.
+func (n *Node) Leaves() []int {
+  if n.IsLeaf() {
+    return []int{ n.Leaf }
+  }
+  return append(n.Left.Leaves(), n.Right.Leaves()...)
+}
+
+// Agglomerate clusters the inputs of a distance matrix hierarchically,
+// joining the two closest clusters until only one is left, which it
+// returns. Ties join the clusters that were formed first.
+func Agglomerate(m [][]float64, l Linkage) (*Node, error) {
+  for i := range m {
+    if len(m[i]) != len(m) {
+      return nil, errors.New("distance matrix is not square")
+    }
+  }
+  if len(m) == 0 {
+    return nil, errors.New("there is nothing to cluster")
+  }
+
+  nodes := make([]*Node, len(m))
+  dist := make([][]float64, len(m))
+  for i := range m {
+    nodes[i] = &Node{ Leaf: i, Size: 1 }
+    dist[i] = append([]float64{}, m[i]...)
+  }
+
+  for len(nodes) > 1 {
+    a, b := 0, 1
+    for i := range nodes {
+      for j := i + 1; j < len(nodes); j++ {
+        if dist[i][j] < dist[a][b] {
+          a, b = i, j
+        }
+      }
+    }
+
+    joined := &Node{ Leaf: -1, Left: nodes[a], Right: nodes[b],
+      Height: dist[a][b], Size: nodes[a].Size + nodes[b].Size }
+
+    // The joined cluster takes the place of `a`, and `b` is dropped.
+    for k := range nodes {
+      if k == a || k == b {
+        continue
+      }
+      d := link(l, dist[a][k], dist[b][k], nodes[a].Size, nodes[b].Size)
+      dist[a][k], dist[k][a] = d, d
+    }
+    nodes[a] = joined
+
+    nodes = append(nodes[:b], nodes[b+1:]...)
+    dist = append(dist[:b], dist[b+1:]...)
+    for k := range dist {
+      dist[k] = append(dist[k][:b], dist[k][b+1:]...)
+    }
+  }
+  return nodes[0], nil
+}
+
+// link returns the distance from a cluster joined from clusters of sizes
+// `na` and `nb` to another cluster, given their own distances to it.
+func link(l Linkage, da, db float64, na, nb int) float64 {
+  switch l {
+  case Single:
+    return math.Min(da, db)
+  case Complete:
+    return math.Max(da, db)
+  }
+  return (da * float64(na) + db * float64(nb)) / float64(na + nb)
+}
+
+// WriteDendrogram draws the cluster as a tree of text, labeling the joins
+// with their heights and the leaves with `names`.
+func WriteDendrogram(w io.Writer, n *Node, names []string) error {
+  return writeNode(w, n, names, "", "")
+}
+
+func writeNode(w io.Writer, n *Node, names []string, first, rest string) error {
+  if n.IsLeaf() {
+    name := fmt.Sprintf("#%d", n.Leaf)
+    if n.Leaf < len(names) {
+      name = names[n.Leaf]
+    }
+    _, err := fmt.Fprintf(w, "%s%s\n", first, name)
+    return err
+  }
+
+  if _, err := fmt.Fprintf(w, "%s+ %.4f\n", first, n.Height); err != nil {
+    return err
+  }
+  if err := writeNode(w, n.Left, names, rest + "|-- ", rest + "|   "); err != nil {
+    return err
+  }
+  return writeNode(w, n.Right, names, rest + "`-- ", rest + "    ")
+}
@@ -0,0 +1,98 @@
+package cluster_test
+
+import(
+  "bytes"
+  "reflect"
+  "testing"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/cluster"
+  "github.com/pjrebsch/mizudiff/digest"
+)
+
+func TestMatrix(t *testing.T) {
+  ins := [][]byte{
+    { 0x12, 0x34 },
+    { 0x12, 0x35 },
+    {},
+  }
+  ds := []digest.Digest{}
+  for _, in := range ins {
+    d, _ := digest.New(bitstr.New(in))
+    ds = append(ds, d)
+  }
+
+  m, err := cluster.Matrix(ds)
+  if err != nil {
+    t.Fatalf("Matrix(): did not expect an error, but got one: %v", err)
+  }
+  expected := [][]float64{
+    { 0, 0.5, 1 },
+    { 0.5, 0, 1 },
+    { 1, 1, 0 },
+  }
+  if !reflect.DeepEqual(m, expected) {
+    t.Errorf("Matrix(): expected %v, got %v", expected, m)
+  }
+}
+
+func TestAgglomerate(t *testing.T) {
+  m := [][]float64{
+    { 0, 0.1, 0.8, 0.9 },
+    { 0.1, 0, 0.7, 0.8 },
+    { 0.8, 0.7, 0, 0.2 },
+    { 0.9, 0.8, 0.2, 0 },
+  }
+
+  var tbl = []struct {
+    linkage cluster.Linkage
+    height float64
+  }{
+    { cluster.Average, 0.8 },
+    { cluster.Single, 0.7 },
+    { cluster.Complete, 0.9 },
+  }
+  for _, e := range tbl {
+    n, err := cluster.Agglomerate(m, e.linkage)
+    if err != nil {
+      t.Fatalf("Agglomerate(%v): did not expect an error, but got one: %v", e.linkage, err)
+    }
+    if n.Size != 4 || n.Height != e.height {
+      t.Errorf("Agglomerate(%v): expected 4 inputs joined at %v, got %d at %v",
+        e.linkage, e.height, n.Size, n.Height)
+    }
+    if l := n.Leaves(); !reflect.DeepEqual(l, []int{ 0, 1, 2, 3 }) {
+      t.Errorf("Agglomerate(%v): expected leaves 0 1 2 3, got %v", e.linkage, l)
+    }
+    if n.Left.Height != 0.1 || n.Right.Height != 0.2 {
+      t.Errorf("Agglomerate(%v): expected pairs joined at 0.1 and 0.2, got %v and %v",
+        e.linkage, n.Left.Height, n.Right.Height)
+    }
+  }
+
+  if _, err := cluster.Agglomerate([][]float64{ { 0, 1 } }, cluster.Average); err == nil {
+    t.Errorf("Agglomerate(): expected an error for a matrix that isn't square")
+  }
+}
+
+func TestWriteDendrogram(t *testing.T) {
+  m := [][]float64{
+    { 0, 0.25, 1 },
+    { 0.25, 0, 1 },
+    { 1, 1, 0 },
+  }
+  n, _ := cluster.Agglomerate(m, cluster.Average)
+
+  var buf bytes.Buffer
+  if err := cluster.WriteDendrogram(&buf, n, []string{ "a", "b", "c" }); err != nil {
+    t.Fatalf("WriteDendrogram(): did not expect an error, but got one: %v", err)
+  }
+
+  expected := "+ 1.0000\n" +
+    "|-- + 0.2500\n" +
+    "|   |-- a\n" +
+    "|   `-- b\n" +
+    "`-- c\n"
+  if buf.String() != expected {
+    t.Errorf("WriteDendrogram(): expected\n%s\ngot\n%s", expected, buf.String())
+  }
+}
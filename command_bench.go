@@ -0,0 +1,113 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "math/rand"
+  "os"
+  "time"
+  "github.com/pjrebsch/mizudiff/bitpos"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/eval"
+)
+
+func benchCommand(args []string) error {
+  flags := flag.NewFlagSet("bench", flag.ExitOnError)
+  configs := flags.String("configs", "1:8,2:8,2:16,4:32,8:64,64:256", "window layouts to measure, as advance:window in bits")
+  count := flags.Int("count", 3, "runs of each layout, of which the fastest is reported")
+  mutations := flags.Int("mutations", 10, "random changes made to the copy of FILE that the digests are diffed against")
+  opts := inputOptions{}
+  opts.register(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff bench [flags] FILE")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Digests FILE with each window layout and reports how fast that is, how")
+    fmt.Fprintln(os.Stderr, "fast the digest diffs against that of a randomly changed copy and how")
+    fmt.Fprintln(os.Stderr, "big the digest is as a percentage of FILE. Digest speed is in bytes of")
+    fmt.Fprintln(os.Stderr, "FILE and diff speed in bytes of digest.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 1 || *count < 1 || *mutations < 0 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  cs, err := eval.ParseConfigs(*configs)
+  if err != nil {
+    return err
+  }
+  f, err := opts.readFile(flags.Arg(0))
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  src, s := f.Bytes(), f.BitString()
+
+  m := eval.Mutator{ Rand: rand.New(rand.NewSource(1)), Kinds: eval.Kinds, MaxLength: 64 }
+  changed, _, _ := m.Mutate(src, *mutations)
+
+  fmt.Printf("%-8s  %12s  %10s  %12s  %10s  %12s  %8s\n",
+    "config", "digest", "MB/s", "diff", "MB/s", "digest bytes", "ratio")
+  for _, c := range cs {
+    var d bitstr.BitString
+    digestTime, err := fastest(*count, func() (err error) {
+      d, err = s.XORCompress(c.Advance, c.Window)
+      return err
+    })
+    if err != nil {
+      return err
+    }
+
+    other, err := bitstr.Wrap(changed).XORCompress(c.Advance, c.Window)
+    if err != nil {
+      return err
+    }
+    w := bitpos.New(0, int64(c.Window))
+    diffTime, err := fastest(*count, func() error {
+      _, err := bitstr.Diff(d, other, w)
+      return err
+    })
+    if err != nil {
+      return err
+    }
+
+    n, err := d.Length().CeilByteOffset()
+    if err != nil {
+      return err
+    }
+    ratio := 0.0
+    if len(src) > 0 {
+      ratio = float64(n) / float64(len(src)) * 100
+    }
+    fmt.Printf("%-8s  %12s  %10.2f  %12s  %10.2f  %12d  %7.2f%%\n", c,
+      digestTime.Round(time.Microsecond), throughput(len(src), digestTime),
+      diffTime.Round(time.Microsecond), throughput(int(n), diffTime),
+      n, ratio)
+  }
+  return nil
+}
+
+// fastest runs `f` `count` times and returns the shortest time it took.
+func fastest(count int, f func() error) (time.Duration, error) {
+  best := time.Duration(0)
+  for i := 0; i < count; i++ {
+    start := time.Now()
+    if err := f(); err != nil {
+      return 0, err
+    }
+    if d := time.Since(start); i == 0 || d < best {
+      best = d
+    }
+  }
+  return best, nil
+}
+
+// throughput returns how many megabytes a second `n` bytes in `d` make for.
+func throughput(n int, d time.Duration) float64 {
+  if d <= 0 {
+    return 0
+  }
+  return float64(n) / 1e6 / d.Seconds()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io"
+  "os"
+  "strconv"
+  "strings"
+  "github.com/pjrebsch/mizudiff/cluster"
+  "github.com/pjrebsch/mizudiff/digest"
+)
+
+func compareManyCommand(args []string) error {
+  flags := flag.NewFlagSet("compare-many", flag.ExitOnError)
+  out := flags.String("o", "", "where to write the consensus digest")
+  opts := inputOptions{}
+  opts.register(flags)
+  opts.registerStore(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff compare-many [flags] FILE FILE...")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Votes on every window of the files' digests and lists the files that")
+    fmt.Fprintln(os.Stderr, "differ from the majority, with where they differ.")
+    fmt.Fprintln(os.Stderr, "Exits with 0 if they all agree, 1 if some differ and 2 on error.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() < 2 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  s, err := opts.openStore()
+  if err != nil {
+    return err
+  }
+  ds := make([]digest.Digest, flags.NArg())
+  for i, path := range flags.Args() {
+    e, err := opts.digestFile(s, path)
+    if err != nil {
+      return err
+    }
+    ds[i] = e.Digest
+  }
+
+  c, err := digest.NewConsensus(ds)
+  if err != nil {
+    return err
+  }
+
+  windows := int64(len(c.Agreement))
+  unanimous := c.Unanimous()
+  percent := 100.0
+  if windows > 0 {
+    percent = float64(unanimous) / float64(windows) * 100
+  }
+  fmt.Printf("%d inputs, %d windows, %d unanimous (%.2f%%)\n",
+    len(ds), windows, unanimous, percent)
+
+  outliers := 0
+  for i, ss := range c.Outliers {
+    if len(ss) == 0 {
+      continue
+    }
+    outliers++
+
+    n := int64(0)
+    for _, s := range ss {
+      n += s.To - s.From
+    }
+    n = (n + int64(ds[i].Config.WindowSize()) - 1) / int64(ds[i].Config.WindowSize())
+
+    rs := digest.MergeSpans(ss)
+    bs := make([]string, len(rs))
+    for j, r := range rs {
+      bs[j] = fmt.Sprintf("%d-%d", r.Offset, r.End() - 1)
+    }
+    fmt.Printf("outlier  %s  %d windows at bytes %s\n",
+      flags.Arg(i), n, strings.Join(bs, ", "))
+  }
+
+  if *out != "" {
+    raw, err := c.Digest.Bytes()
+    if err != nil {
+      return err
+    }
+    if err := writeFileAtomic(*out, raw); err != nil {
+      return err
+    }
+  }
+
+  if outliers > 0 {
+    return errDifferent
+  }
+  return nil
+}
+
+func clusterCommand(args []string) error {
+  flags := flag.NewFlagSet("cluster", flag.ExitOnError)
+  format := flags.String("format", "text", "output format: text for a dendrogram, csv for the distance matrix, or json for both")
+  linkage := flags.String("linkage", "average", "how far apart clusters are: average, single or complete")
+  opts := inputOptions{}
+  opts.register(flags)
+  opts.registerStore(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff cluster [flags] FILE FILE...")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Measures the distance between every pair of files, as the fraction of")
+    fmt.Fprintln(os.Stderr, "their digest windows that differ, and clusters them by it.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() < 2 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+  l, err := cluster.ParseLinkage(*linkage)
+  if err != nil {
+    return err
+  }
+
+  s, err := opts.openStore()
+  if err != nil {
+    return err
+  }
+  ds := make([]digest.Digest, flags.NArg())
+  for i, path := range flags.Args() {
+    e, err := opts.digestFile(s, path)
+    if err != nil {
+      return err
+    }
+    ds[i] = e.Digest
+  }
+
+  m, err := cluster.Matrix(ds)
+  if err != nil {
+    return err
+  }
+  root, err := cluster.Agglomerate(m, l)
+  if err != nil {
+    return err
+  }
+
+  switch *format {
+  case "text":
+    return cluster.WriteDendrogram(os.Stdout, root, flags.Args())
+  case "csv":
+    return writeMatrixCSV(os.Stdout, flags.Args(), m)
+  case "json":
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetIndent("", "  ")
+    return enc.Encode(clusterReport{
+      Inputs: flags.Args(),
+      Linkage: l.String(),
+      Distances: m,
+      Dendrogram: newDendrogram(root, flags.Args()),
+    })
+  }
+  return fmt.Errorf("unknown output format: %s", *format)
+}
+
+// writeMatrixCSV writes the distance matrix with the input names heading
+// both the rows and the columns.
+func writeMatrixCSV(w io.Writer, names []string, m [][]float64) error {
+  cw := csv.NewWriter(w)
+  cw.Write(append([]string{ "" }, names...))
+  for i, row := range m {
+    rec := []string{ names[i] }
+    for _, d := range row {
+      rec = append(rec, strconv.FormatFloat(d, 'f', -1, 64))
+    }
+    cw.Write(rec)
+  }
+  cw.Flush()
+  return cw.Error()
+}
+
+type clusterReport struct {
+  Inputs []string `json:"inputs"`
+  Linkage string `json:"linkage"`
+  Distances [][]float64 `json:"distances"`
+  Dendrogram dendrogram `json:"dendrogram"`
+}
+
+// dendrogram is a cluster.Node as it appears in JSON.
+type dendrogram struct {
+  Input string `json:"input,omitempty"`
+  Height float64 `json:"height"`
+  Children []dendrogram `json:"children,omitempty"`
+}
+
+func newDendrogram(n *cluster.Node, names []string) dendrogram {
+  if n.IsLeaf() {
+    return dendrogram{ Input: names[n.Leaf] }
+  }
+  return dendrogram{ Height: n.Height, Children: []dendrogram{
+    newDendrogram(n.Left, names), newDendrogram(n.Right, names),
+  } }
+}
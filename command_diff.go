@@ -0,0 +1,458 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "os/signal"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "github.com/pjrebsch/mizudiff/archive"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/render"
+  "github.com/pjrebsch/mizudiff/report"
+  "github.com/pjrebsch/mizudiff/source"
+  "github.com/pjrebsch/mizudiff/store"
+  "github.com/pjrebsch/mizudiff/tree"
+)
+
+func diffCommand(args []string) error {
+  flags := flag.NewFlagSet("diff", flag.ExitOnError)
+  opts := inputOptions{}
+  opts.register(flags)
+  opts.registerStore(flags)
+  format := flags.String("format", "text", "output format: text, heatmap, html, svg or json")
+  asJSON := flags.Bool("json", false, "shorthand for -format json")
+  width := flags.Int("width", terminalWidth(), "columns available to the heatmap")
+  color := flags.Bool("color", false, "color the heatmap with ANSI escapes")
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff diff [flags] A B")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "A and B are both files, or each a directory or saved tree digest.")
+    fmt.Fprintln(os.Stderr, "With -store, a file can also be given as the ID of a stored digest.")
+    fmt.Fprintln(os.Stderr, "Exits with 0 if they're identical, 1 if they differ and 2 on error.")
+    fmt.Fprintln(os.Stderr, "Files are only identical when their SHA-256 sums match, so trees saved")
+    fmt.Fprintln(os.Stderr, "before sums were recorded always differ.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 2 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  cs, err := compare(flags.Arg(0), flags.Arg(1), opts)
+  if err != nil {
+    return err
+  }
+
+  if *asJSON {
+    *format = "json"
+  }
+
+  switch *format {
+  case "json":
+    inputs := []report.Input{
+      describeInput(flags.Arg(0), opts), describeInput(flags.Arg(1), opts),
+    }
+    err = report.New(inputs, cs).WriteJSON(os.Stdout)
+  case "text":
+    printChanges(os.Stdout, cs)
+  case "heatmap":
+    err = printHeatmaps(os.Stdout, cs,
+      render.HeatmapOptions{ Width: *width, Color: *color })
+  case "html":
+    title := fmt.Sprintf("mizudiff: %s vs %s", flags.Arg(0), flags.Arg(1))
+    err = render.HTML(os.Stdout, title, strips(cs))
+  case "svg":
+    err = render.SVG(os.Stdout, strips(cs))
+  default:
+    err = fmt.Errorf("unknown output format: %s", *format)
+  }
+
+  if err == nil && len(cs) > 0 {
+    return errDifferent
+  }
+  return err
+}
+
+// describeInput says how compare will have treated `path`.
+func describeInput(path string, opts inputOptions) report.Input {
+  in := report.Input{
+    Path: path, Kind: "file", Encoding: opts.encoding.String(), Gunzip: opts.gunzip,
+  }
+
+  if info, err := os.Stat(path); err == nil && info.IsDir() {
+    in.Kind = "directory"
+    return in
+  }
+  if f, err := os.Open(path); err == nil {
+    magic := make([]byte, len(tree.Magic))
+    if _, err := io.ReadFull(f, magic); err == nil && tree.IsTree(magic) {
+      in.Kind = "tree"
+    }
+    f.Close()
+  }
+  if in.Kind == "file" && opts.archive {
+    in.Kind = "archive"
+  }
+  return in
+}
+
+// terminalWidth guesses the width of the terminal from $COLUMNS.
+func terminalWidth() int {
+  if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+    return n
+  }
+  return 80
+}
+
+// strips picks out the changed files that can be drawn in a report.
+func strips(cs []tree.Change) []render.Strip {
+  ss := []render.Strip{}
+  for _, c := range cs {
+    if c.Kind == tree.Changed && c.Config != nil {
+      ss = append(ss, render.Strip{ Name: c.Path, SizeA: c.SizeA, SizeB: c.SizeB,
+        Config: c.Config, Diff: c.Diff, Spans: c.Spans })
+    }
+  }
+  return ss
+}
+
+// printHeatmaps lists the changes like printChanges, drawing a heatmap
+// under every changed file that has one.
+func printHeatmaps(w io.Writer, cs []tree.Change, opts render.HeatmapOptions) error {
+  for _, c := range cs {
+    printChanges(w, []tree.Change{ c })
+    if c.Kind != tree.Changed || c.Config == nil {
+      continue
+    }
+    if err := render.Heatmap(w, c.Diff, c.Config, opts); err != nil {
+      return err
+    }
+    fmt.Fprintln(w)
+  }
+  return nil
+}
+
+// inputOptions controls how files are read before they're digested.
+type inputOptions struct {
+  encoding source.Encoding
+  gunzip bool
+  archive bool
+  store string
+}
+
+func (o *inputOptions) register(flags *flag.FlagSet) {
+  flags.Func("encoding", "how inputs are stored: raw, hex, base64 or xxd (default: raw)",
+    func(name string) error {
+      e, err := source.ParseEncoding(name)
+      o.encoding = e
+      return err
+    })
+  flags.BoolVar(&o.gunzip, "gunzip", false, "decompress gzip files before digesting them")
+  flags.BoolVar(&o.archive, "archive", false, "digest tar and zip files (gzipped or not) member by member")
+}
+
+// registerStore adds the -store flag for commands that can use the store
+// without being about it.
+func (o *inputOptions) registerStore(flags *flag.FlagSet) {
+  flags.StringVar(&o.store, "store", "", "reuse digests kept in this store, and accept their IDs in place of files")
+}
+
+// openStore opens the store that was asked for, or returns nil.
+func (o inputOptions) openStore() (*store.Store, error) {
+  if o.store == "" {
+    return nil, nil
+  }
+  return openStore(o.store)
+}
+
+// digestFile digests a plain file as an entry named after it. The digest
+// is taken from `s` when it isn't nil and holds the file's, and `path` can
+// be the ID of a digest stored in `s` that isn't a file.
+func (o inputOptions) digestFile(s *store.Store, path string) (tree.Entry, error) {
+  info, err := os.Stat(path)
+  if os.IsNotExist(err) && s != nil {
+    id, err := s.Resolve(path)
+    if err != nil {
+      return tree.Entry{}, err
+    }
+    e, err := s.Get(id)
+    if err != nil {
+      return tree.Entry{}, err
+    }
+    return tree.Entry{ Path: e.Meta.Name, Mode: e.Meta.Mode, Size: e.Meta.Size,
+      Digest: e.Digest, Sum: e.ID }, nil
+  }
+  if err != nil {
+    return tree.Entry{}, err
+  }
+
+  src, err := o.readFile(path)
+  if err != nil {
+    return tree.Entry{}, err
+  }
+  defer src.Close()
+  data := src.Bytes()
+  entry := tree.Entry{ Path: filepath.Base(path), Mode: info.Mode(),
+    Size: int64(len(data)), Sum: store.Sum(data) }
+
+  d, ok, err := storedDigest(s, path, data)
+  if err != nil || ok {
+    entry.Digest = d
+    return entry, err
+  }
+  entry.Digest, err = digest.NewContext(logContext(), src.BitString(), nil)
+  return entry, err
+}
+
+// storedDigest looks for the digest of `data`, read from `path`, in `s`.
+// The boolean is false when `s` is nil or the digest isn't there.
+func storedDigest(s *store.Store, path string, data []byte) (digest.Digest, bool, error) {
+  if s == nil {
+    return digest.Digest{}, false, nil
+  }
+  e, err := s.Get(store.Sum(data))
+  if errors.Is(err, store.ErrNotFound) {
+    return digest.Digest{}, false, nil
+  }
+  if err != nil {
+    return digest.Digest{}, false, err
+  }
+  logger.Info("reusing stored digest", "path", path, "id", e.ID.String())
+  return e.Digest, true, nil
+}
+
+// decodeFile opens a file and decodes it. The caller closes the source once
+// it is done with its bytes.
+func (o inputOptions) decodeFile(path string) (*source.Source, error) {
+  return source.OpenContext(logContext(), path, o.encoding)
+}
+
+// readFile decodes a file and decompresses it if requested. The caller
+// closes the source once it is done with its bytes.
+func (o inputOptions) readFile(path string) (*source.Source, error) {
+  s, err := o.decodeFile(path)
+  if err != nil || !o.gunzip {
+    return s, err
+  }
+  defer s.Close()
+  data, err := archive.Decompress(s.Bytes())
+  if err != nil {
+    return nil, err
+  }
+  return source.New(path, data), nil
+}
+
+// compare diffs two files, or two trees given as directories, saved tree
+// digests or, when requested, archives.
+func compare(a, b string, opts inputOptions) ([]tree.Change, error) {
+  ta, aIsTree, err := loadTree(logContext(), a, opts)
+  if err != nil {
+    return nil, err
+  }
+  tb, bIsTree, err := loadTree(logContext(), b, opts)
+  if err != nil {
+    return nil, err
+  }
+
+  if aIsTree != bIsTree {
+    return nil, fmt.Errorf("can't compare %s with %s: only one is a tree", a, b)
+  }
+  if aIsTree {
+    return tree.CompareContext(logContext(), ta, tb)
+  }
+
+  // Plain files are compared as single entry trees, named after the second
+  // file.
+  s, err := opts.openStore()
+  if err != nil {
+    return nil, err
+  }
+  ea, err := opts.digestFile(s, a)
+  if err != nil {
+    return nil, err
+  }
+  eb, err := opts.digestFile(s, b)
+  if err != nil {
+    return nil, err
+  }
+  ea.Path = eb.Path
+  return tree.CompareContext(logContext(), tree.Tree{ Entries: []tree.Entry{ ea } },
+    tree.Tree{ Entries: []tree.Entry{ eb } })
+}
+
+// loadTree walks `path` when it's a directory, loads it when it's a saved
+// tree digest and unpacks it when it's an archive and archives were asked
+// for. The boolean is false for any other file. Digesting stops when `ctx`
+// is done.
+func loadTree(ctx context.Context, path string, opts inputOptions) (tree.Tree, bool, error) {
+  info, err := os.Stat(path)
+  if os.IsNotExist(err) && opts.store != "" {
+    // It may be the ID of a stored digest.
+    return tree.Tree{}, false, nil
+  }
+  if err != nil {
+    return tree.Tree{}, false, err
+  }
+  if info.IsDir() {
+    t, err := tree.WalkContext(ctx, path)
+    return t, true, err
+  }
+
+  f, err := os.Open(path)
+  if err != nil {
+    return tree.Tree{}, false, err
+  }
+  magic := make([]byte, len(tree.Magic))
+  _, err = io.ReadFull(f, magic)
+  f.Close()
+  isTree := err == nil && tree.IsTree(magic)
+
+  if !isTree && opts.archive {
+    src, err := opts.decodeFile(path)
+    if err != nil {
+      return tree.Tree{}, false, err
+    }
+    defer src.Close()
+    t, err := archive.TreeContext(ctx, src.Bytes(), filepath.Base(path))
+    return t, true, err
+  }
+  if !isTree {
+    return tree.Tree{}, false, nil
+  }
+
+  raw, err := ioutil.ReadFile(path)
+  if err != nil {
+    return tree.Tree{}, false, err
+  }
+  t, err := tree.Load(raw)
+  return t, true, err
+}
+
+func printChanges(w io.Writer, cs []tree.Change) {
+  for _, c := range cs {
+    switch c.Kind {
+    case tree.Added:
+      fmt.Fprintf(w, "added    %s (%d bytes)\n", c.Path, c.SizeB)
+    case tree.Removed:
+      fmt.Fprintf(w, "removed  %s (%d bytes)\n", c.Path, c.SizeA)
+    case tree.Changed:
+      fmt.Fprintf(w, "changed  %s", c.Path)
+      if c.ModeA != c.ModeB {
+        fmt.Fprintf(w, " mode %v -> %v", c.ModeA, c.ModeB)
+      }
+      if c.SizeA != c.SizeB {
+        fmt.Fprintf(w, " size %d -> %d", c.SizeA, c.SizeB)
+      }
+      if len(c.Regions) > 0 {
+        rs := make([]string, len(c.Regions))
+        for i, r := range c.Regions {
+          rs[i] = fmt.Sprintf("%d-%d", r.Offset, r.End() - 1)
+        }
+        fmt.Fprintf(w, " at bytes %s", strings.Join(rs, ", "))
+      }
+      fmt.Fprintln(w)
+    }
+  }
+}
+
+func digestCommand(args []string) error {
+  flags := flag.NewFlagSet("digest", flag.ExitOnError)
+  out := flags.String("o", "", "where to write the digest (default: standard output)")
+  showProgress := flags.Bool("progress", isTerminal(os.Stderr), "draw a progress bar while digesting a file")
+  opts := inputOptions{}
+  opts.register(flags)
+  opts.registerStore(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff digest [flags] PATH")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "A directory, or an archive with -archive, is saved as a tree digest.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 1 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+  path := flags.Arg(0)
+
+  // An interrupt stops digesting instead of killing the process, so that
+  // nothing is written.
+  ctx, stop := signal.NotifyContext(logContext(), os.Interrupt)
+  defer stop()
+
+  info, err := os.Stat(path)
+  if err != nil {
+    return err
+  }
+
+  var raw []byte
+  if info.IsDir() || opts.archive {
+    t, _, err := loadTree(ctx, path, opts)
+    if ctx.Err() != nil {
+      return errInterrupted
+    }
+    if err != nil {
+      return err
+    }
+    raw, err = t.Bytes()
+    if err != nil {
+      return err
+    }
+  } else {
+    src, err := opts.readFile(path)
+    if err != nil {
+      return err
+    }
+    defer src.Close()
+    data := src.Bytes()
+
+    s, err := opts.openStore()
+    if err != nil {
+      return err
+    }
+    d, ok, err := storedDigest(s, path, data)
+    if err != nil {
+      return err
+    }
+    if !ok {
+      var progress bitstr.Progress
+      if *showProgress {
+        bar := newProgressBar(os.Stderr, filepath.Base(path))
+        defer bar.finish()
+        progress = bar.update
+      }
+
+      d, err = digest.NewContext(ctx, src.BitString(), progress)
+      if ctx.Err() != nil {
+        return errInterrupted
+      }
+      if err != nil {
+        return err
+      }
+    }
+    raw, err = d.Bytes()
+    if err != nil {
+      return err
+    }
+  }
+
+  if ctx.Err() != nil {
+    return errInterrupted
+  }
+  if *out == "" {
+    _, err := os.Stdout.Write(raw)
+    return err
+  }
+  return writeFileAtomic(*out, raw)
+}
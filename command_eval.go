@@ -0,0 +1,61 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "math/rand"
+  "os"
+  "github.com/pjrebsch/mizudiff/eval"
+)
+
+func evalCommand(args []string) error {
+  flags := flag.NewFlagSet("eval", flag.ExitOnError)
+  configs := flags.String("configs", "1:8,2:16,4:32,8:64", "window layouts to evaluate, as advance:window in bits")
+  kinds := flags.String("kinds", "byte,bit,insert,delete,move", "kinds of mutation to make")
+  n := flags.Int("n", 1, "mutations per trial")
+  trials := flags.Int("trials", 100, "number of trials")
+  length := flags.Int64("len", 16, "longest run of bytes to insert, delete or move")
+  seed := flags.Int64("seed", 1, "seed for the random mutations")
+  opts := inputOptions{}
+  opts.register(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff eval [flags] FILE")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Makes random mutations to FILE and reports, for each window layout,")
+    fmt.Fprintln(os.Stderr, "how precisely the diff finds the changed bytes and how many it finds.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 1 || *n < 1 || *trials < 1 || *length < 1 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  cs, err := eval.ParseConfigs(*configs)
+  if err != nil {
+    return err
+  }
+  ks, err := eval.ParseKinds(*kinds)
+  if err != nil {
+    return err
+  }
+  src, err := opts.readFile(flags.Arg(0))
+  if err != nil {
+    return err
+  }
+  defer src.Close()
+
+  m := eval.Mutator{ Rand: rand.New(rand.NewSource(*seed)), Kinds: ks, MaxLength: *length }
+  scores, err := eval.Trials(m, src.Bytes(), *n, *trials, cs)
+  if err != nil {
+    return err
+  }
+
+  fmt.Printf("%-8s  %9s  %6s  %10s  %10s\n", "config", "precision", "recall", "reported", "changed")
+  for i, c := range cs {
+    s := scores[i]
+    fmt.Printf("%-8s  %9.3f  %6.3f  %10d  %10d\n", c, s.Precision(), s.Recall(), s.Detected, s.Changed)
+  }
+  return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "os"
+  "strings"
+)
+
+// progressBar draws a bar that fills as work is reported to update.
+type progressBar struct {
+  w io.Writer
+  label string
+  width int
+  percent int
+}
+
+func newProgressBar(w io.Writer, label string) *progressBar {
+  return &progressBar{ w: w, label: label, width: 30, percent: -1 }
+}
+
+// update redraws the bar, but only when the percentage has changed.
+func (p *progressBar) update(done, total int64) {
+  percent := 100
+  if total > 0 {
+    percent = int(done * 100 / total)
+  }
+  if percent == p.percent {
+    return
+  }
+  p.percent = percent
+
+  fill := percent * p.width / 100
+  fmt.Fprintf(p.w, "\r%s [%s%s] %3d%%", p.label,
+    strings.Repeat("#", fill), strings.Repeat("-", p.width - fill), percent)
+}
+
+// finish moves past the bar if it was ever drawn.
+func (p *progressBar) finish() {
+  if p.percent >= 0 {
+    fmt.Fprintln(p.w)
+  }
+}
+
+// isTerminal reports whether `f` looks like a terminal rather than a file
+// or pipe.
+func isTerminal(f *os.File) bool {
+  info, err := f.Stat()
+  return err == nil && info.Mode() & os.ModeCharDevice != 0
+}
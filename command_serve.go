@@ -0,0 +1,101 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "os"
+  "path/filepath"
+  "github.com/pjrebsch/mizudiff/transfer"
+)
+
+func serveCommand(args []string) error {
+  flags := flag.NewFlagSet("serve", flag.ExitOnError)
+  network := flags.String("network", "tcp", "network to listen on: tcp or unix")
+  addr := flags.String("addr", "localhost:7345", "address or socket path to listen on")
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff serve [flags] FILE")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 1 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  b, err := ioutil.ReadFile(flags.Arg(0))
+  if err != nil {
+    return err
+  }
+
+  l, err := net.Listen(*network, *addr)
+  if err != nil {
+    return err
+  }
+  defer l.Close()
+
+  logger.Info("serving", "file", flags.Arg(0),
+    "network", l.Addr().Network(), "addr", l.Addr().String())
+  return transfer.ServeContext(logContext(), l, b)
+}
+
+func pullCommand(args []string) error {
+  flags := flag.NewFlagSet("pull", flag.ExitOnError)
+  network := flags.String("network", "tcp", "network to connect over: tcp or unix")
+  addr := flags.String("addr", "localhost:7345", "address or socket path of the server")
+  out := flags.String("o", "", "where to write the result (default: FILE itself)")
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff pull [flags] FILE")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 1 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+  name := flags.Arg(0)
+  if *out == "" {
+    *out = name
+  }
+
+  // A missing local copy just means everything has to be pulled.
+  a, err := ioutil.ReadFile(name)
+  if err != nil && !os.IsNotExist(err) {
+    return err
+  }
+
+  conn, err := net.Dial(*network, *addr)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+
+  b, err := transfer.PullContext(logContext(), conn, a)
+  if err != nil {
+    return err
+  }
+
+  return writeFileAtomic(*out, b)
+}
+
+// writeFileAtomic writes to a temporary file next to `name` and renames it
+// into place, so `name` never holds partial data.
+func writeFileAtomic(name string, b []byte) error {
+  f, err := ioutil.TempFile(filepath.Dir(name), "." + filepath.Base(name) + ".")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(f.Name())
+
+  if _, err := f.Write(b); err != nil {
+    f.Close()
+    return err
+  }
+  if err := f.Close(); err != nil {
+    return err
+  }
+  return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "path/filepath"
+  "time"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/store"
+)
+
+// storeCommands maps each `store` subcommand to the function that runs it.
+var storeCommands = map[string]func(args []string) error {
+  "add": storeAddCommand,
+  "get": storeGetCommand,
+  "list": storeListCommand,
+  "gc": storeGCCommand,
+}
+
+func storeUsage() {
+  fmt.Fprintln(os.Stderr, "usage: mizudiff store <command> [arguments]")
+  fmt.Fprintln(os.Stderr, "")
+  fmt.Fprintln(os.Stderr, "commands:")
+  fmt.Fprintln(os.Stderr, "  add   digest files into the store")
+  fmt.Fprintln(os.Stderr, "  get   write out a stored digest")
+  fmt.Fprintln(os.Stderr, "  list  list the stored digests")
+  fmt.Fprintln(os.Stderr, "  gc    remove unused and broken entries")
+  fmt.Fprintln(os.Stderr, "")
+  fmt.Fprintln(os.Stderr, "Digests are named by the SHA-256 of their source. Any unique prefix")
+  fmt.Fprintln(os.Stderr, "of at least two hex digits can be used in its place.")
+}
+
+func storeCommand(args []string) error {
+  if len(args) < 1 {
+    storeUsage()
+    os.Exit(exitError)
+  }
+  cmd, ok := storeCommands[args[0]]
+  if !ok {
+    storeUsage()
+    os.Exit(exitError)
+  }
+  return cmd(args[1:])
+}
+
+// defaultStoreDir is $MIZUDIFF_STORE, or a directory in the user's cache.
+func defaultStoreDir() string {
+  if dir := os.Getenv("MIZUDIFF_STORE"); dir != "" {
+    return dir
+  }
+  if dir, err := os.UserCacheDir(); err == nil {
+    return filepath.Join(dir, "mizudiff")
+  }
+  return ".mizudiff"
+}
+
+// storeFlag adds the -store flag used by every `store` subcommand.
+func storeFlag(flags *flag.FlagSet) *string {
+  return flags.String("store", defaultStoreDir(), "directory of the digest store")
+}
+
+func storeAddCommand(args []string) error {
+  flags := flag.NewFlagSet("store add", flag.ExitOnError)
+  dir := storeFlag(flags)
+  opts := inputOptions{}
+  opts.register(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff store add [flags] FILE...")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() < 1 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  s, err := openStore(*dir)
+  if err != nil {
+    return err
+  }
+  for _, path := range flags.Args() {
+    info, err := os.Stat(path)
+    if err != nil {
+      return err
+    }
+    src, err := opts.readFile(path)
+    if err != nil {
+      return err
+    }
+    e, err := s.Add(path, info.Mode(), src.Bytes())
+    src.Close()
+    if err != nil {
+      return err
+    }
+    fmt.Printf("%s  %s\n", e.ID, path)
+  }
+  return nil
+}
+
+func storeGetCommand(args []string) error {
+  flags := flag.NewFlagSet("store get", flag.ExitOnError)
+  dir := storeFlag(flags)
+  out := flags.String("o", "", "where to write the digest (default: standard output)")
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff store get [flags] ID")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 1 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  s, err := openStore(*dir)
+  if err != nil {
+    return err
+  }
+  id, err := s.Resolve(flags.Arg(0))
+  if err != nil {
+    return err
+  }
+  e, err := s.Get(id)
+  if err != nil {
+    return err
+  }
+  raw, err := e.Digest.Bytes()
+  if err != nil {
+    return err
+  }
+
+  if *out == "" {
+    _, err := os.Stdout.Write(raw)
+    return err
+  }
+  return writeFileAtomic(*out, raw)
+}
+
+func storeListCommand(args []string) error {
+  flags := flag.NewFlagSet("store list", flag.ExitOnError)
+  dir := storeFlag(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff store list [flags]")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  s, err := openStore(*dir)
+  if err != nil {
+    return err
+  }
+  es, err := s.List()
+  if err != nil {
+    return err
+  }
+  for _, e := range es {
+    fmt.Printf("%s  %10d  %s  %s\n", e.ID, e.Meta.Size,
+      e.Meta.Added.Format(time.RFC3339), e.Meta.Name)
+  }
+  return nil
+}
+
+func storeGCCommand(args []string) error {
+  flags := flag.NewFlagSet("store gc", flag.ExitOnError)
+  dir := storeFlag(flags)
+  unused := flags.Duration("unused", 0, "also remove digests that haven't been used for this long")
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff store gc [flags]")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  s, err := openStore(*dir)
+  if err != nil {
+    return err
+  }
+  before := time.Time{}
+  if *unused > 0 {
+    before = time.Now().Add(-*unused)
+  }
+  n, err := s.GC(before)
+  if err != nil {
+    return err
+  }
+  fmt.Printf("removed %d files\n", n)
+  return nil
+}
+
+func nearestCommand(args []string) error {
+  flags := flag.NewFlagSet("nearest", flag.ExitOnError)
+  dir := storeFlag(flags)
+  n := flags.Int("n", 10, "how many stored digests to list")
+  exhaustive := flags.Bool("exhaustive", false, "compare against every stored digest instead of using the index")
+  opts := inputOptions{}
+  opts.register(flags)
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff nearest [flags] FILE")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Lists the stored digests that FILE most resembles, with the percentage")
+    fmt.Fprintln(os.Stderr, "of windows that are the same.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 1 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+
+  s, err := openStore(*dir)
+  if err != nil {
+    return err
+  }
+  e, err := opts.digestFile(s, flags.Arg(0))
+  if err != nil {
+    return err
+  }
+  var ms []store.Match
+  if *exhaustive {
+    var ids []store.ID
+    ids, err = s.IDs()
+    if err != nil {
+      return err
+    }
+    ms, err = s.Rank(e.Digest, ids, *n)
+  } else {
+    ms, err = s.Nearest(e.Digest, *n)
+  }
+  if err != nil {
+    return err
+  }
+
+  for _, m := range ms {
+    fmt.Printf("%6.2f%%  %s  %s\n", (1 - m.Distance) * 100, m.Entry.ID, m.Entry.Meta.Name)
+  }
+  return nil
+}
+
+func upgradeCommand(args []string) error {
+  versions := digest.RegisteredVersions()
+  flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+  dir := storeFlag(flags)
+  version := flags.Uint("version", uint(versions[len(versions) - 1]), "digest version to upgrade to")
+  flags.Usage = func() {
+    fmt.Fprintln(os.Stderr, "usage: mizudiff upgrade [flags]")
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "Rewrites the stored digests in the given version where that can be")
+    fmt.Fprintln(os.Stderr, "done without their sources.")
+    flags.PrintDefaults()
+  }
+  parseFlags(flags, args)
+
+  if flags.NArg() != 0 {
+    flags.Usage()
+    os.Exit(exitError)
+  }
+  if _, err := digest.Lookup(uint32(*version)); err != nil {
+    return err
+  }
+
+  s, err := openStore(*dir)
+  if err != nil {
+    return err
+  }
+  n, skipped, err := s.Upgrade(uint32(*version))
+  if err != nil {
+    return err
+  }
+  fmt.Printf("upgraded %d digests to version %d\n", n, *version)
+  if skipped > 0 {
+    fmt.Printf("skipped %d digests that need their sources to be digested again\n", skipped)
+  }
+  return nil
+}
@@ -0,0 +1,84 @@
+package digest
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "context"
+  "fmt"
+  "sort"
+  "sync"
+)
+
+// Codec is one version of the digest format: how its config is serialized
+// and how its data is made from a source.
+type Codec interface {
+  // ConfigSize is the length in bytes of a serialized config.
+  ConfigSize() int
+
+  // ParseConfig reads a serialized config of ConfigSize bytes.
+  ParseConfig(raw []byte) (Config, error)
+
+  // EncodeConfig serializes a config of this version, or returns
+  // ErrConfigMismatch for a config of another version.
+  EncodeConfig(c Config) ([]byte, error)
+
+  // NewConfig returns the config that describes the data.
+  NewConfig(data bitstr.BitString) (Config, error)
+
+  // Compress makes the data of a digest from the source, reporting its
+  // progress to `progress`, which may be nil.
+  Compress(ctx context.Context, s bitstr.BitString, progress bitstr.Progress) (bitstr.BitString, error)
+}
+
+var (
+  codecsMu sync.RWMutex
+  codecs = map[uint32]Codec{}
+)
+
+// Versions maps each registered version to the byte length of its configs.
+// Register keeps it up to date, so it must not be read while codecs are
+// still being registered.
+//
+// Deprecated: Use Lookup and RegisteredVersions.
+var Versions = map[uint32]uint16{}
+
+// Register makes a codec available under a version. It panics if the
+// version is already registered, since digests of one version can't be
+// read two ways.
+func Register(version uint32, c Codec) {
+  codecsMu.Lock()
+  defer codecsMu.Unlock()
+
+  if c == nil {
+    panic("digest: Register codec is nil")
+  }
+  if _, dup := codecs[version]; dup {
+    panic(fmt.Sprintf("digest: Register called twice for version 0x%x", version))
+  }
+  codecs[version] = c
+  Versions[version] = uint16(c.ConfigSize())
+}
+
+// Lookup returns the codec registered under a version.
+func Lookup(version uint32) (Codec, error) {
+  codecsMu.RLock()
+  defer codecsMu.RUnlock()
+
+  c, ok := codecs[version]
+  if !ok {
+    return nil, fmt.Errorf("%w: 0x%x", ErrUnknownVersion, version)
+  }
+  return c, nil
+}
+
+// RegisteredVersions returns the registered versions in ascending order.
+func RegisteredVersions() []uint32 {
+  codecsMu.RLock()
+  defer codecsMu.RUnlock()
+
+  vs := make([]uint32, 0, len(codecs))
+  for v := range codecs {
+    vs = append(vs, v)
+  }
+  sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+  return vs
+}
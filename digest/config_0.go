@@ -2,9 +2,16 @@ package digest
 
 import(
   "github.com/pjrebsch/mizudiff/bitpos"
-  "errors"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "context"
+  "encoding/binary"
+  "fmt"
 )
 
+func init() {
+  Register(0x0, codec_0{})
+}
+
 type Config_0 struct {
   ByteLength uint64
   BitLength uint8
@@ -20,7 +27,50 @@ func (c Config_0) DataLength() (bitpos.BitPosition, error) {
 
   if p.Sign() == -1 {
     return bitpos.BitPosition{},
-      errors.New("digest config byte length overflowed int64")
+      fmt.Errorf("%w: config byte length overflowed int64", ErrOverflow)
   }
   return p, nil
 }
+
+// codec_0 is version 0: the source XOR compressed in windows of a byte,
+// advancing a bit at a time, and a config holding only the data length.
+type codec_0 struct{}
+
+func (codec_0) ConfigSize() int {
+  return 9
+}
+
+func (codec_0) ParseConfig(raw []byte) (Config, error) {
+  c := Config_0{}
+  c.ByteLength  = binary.BigEndian.Uint64(raw[0:8])
+  c.BitLength   = uint8(raw[8])
+
+  // Whole bytes belong in the byte length, so that each length has just
+  // one encoding.
+  if c.BitLength >= bitpos.C {
+    return nil,
+      fmt.Errorf("%w: bit length must be less than %d", ErrInvalidConfig, bitpos.C)
+  }
+  return c, nil
+}
+
+func (codec_0) EncodeConfig(config Config) ([]byte, error) {
+  c, ok := config.(Config_0)
+  if !ok {
+    return nil, ErrConfigMismatch
+  }
+  s := make([]byte, 9)
+  binary.BigEndian.PutUint64(s[0:8], c.ByteLength)
+  s[8] = byte(c.BitLength)
+  return s, nil
+}
+
+func (codec_0) NewConfig(data bitstr.BitString) (Config, error) {
+  l := data.Length()
+  return Config_0{ uint64(l.ByteOffset()), uint8(l.BitOffset()) }, nil
+}
+
+func (codec_0) Compress(ctx context.Context, s bitstr.BitString, progress bitstr.Progress) (bitstr.BitString, error) {
+  c := Config_0{}
+  return s.XORCompressContext(ctx, c.AdvanceRate(), c.WindowSize(), progress)
+}
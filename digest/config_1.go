@@ -0,0 +1,88 @@
+package digest
+
+import(
+  "github.com/pjrebsch/mizudiff/bitpos"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "context"
+  "encoding/binary"
+  "fmt"
+  "hash/crc32"
+)
+
+func init() {
+  Register(0x1, codec_1{})
+}
+
+// Config_1 is Config_0 with a checksum of the data, so that a damaged
+// digest is caught when it's loaded.
+type Config_1 struct {
+  ByteLength uint64
+  BitLength uint8
+  Checksum uint32  // CRC-32C of the data
+}
+func (c Config_1) AdvanceRate() uint16 {
+  return 1
+}
+func (c Config_1) WindowSize() uint16 {
+  return 8
+}
+func (c Config_1) DataLength() (bitpos.BitPosition, error) {
+  return Config_0{ c.ByteLength, c.BitLength }.DataLength()
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// codec_1 is version 1: the windows of version 0, with a checksum in the
+// config. Version 0 digests upgrade to it as they are.
+type codec_1 struct{}
+
+func (codec_1) ConfigSize() int {
+  return 13
+}
+
+func (codec_1) ParseConfig(raw []byte) (Config, error) {
+  c0, err := codec_0{}.ParseConfig(raw[0:9])
+  if err != nil {
+    return nil, err
+  }
+  c := Config_1{ ByteLength: c0.(Config_0).ByteLength, BitLength: c0.(Config_0).BitLength }
+  c.Checksum = binary.BigEndian.Uint32(raw[9:13])
+  return c, nil
+}
+
+func (codec_1) EncodeConfig(config Config) ([]byte, error) {
+  c, ok := config.(Config_1)
+  if !ok {
+    return nil, ErrConfigMismatch
+  }
+  s, _ := codec_0{}.EncodeConfig(Config_0{ c.ByteLength, c.BitLength })
+  return binary.BigEndian.AppendUint32(s, c.Checksum), nil
+}
+
+func (codec_1) NewConfig(data bitstr.BitString) (Config, error) {
+  l := data.Length()
+  return Config_1{
+    ByteLength: uint64(l.ByteOffset()),
+    BitLength: uint8(l.BitOffset()),
+    Checksum: crc32.Checksum(rawData(data), castagnoli),
+  }, nil
+}
+
+func (codec_1) Compress(ctx context.Context, s bitstr.BitString, progress bitstr.Progress) (bitstr.BitString, error) {
+  return codec_0{}.Compress(ctx, s, progress)
+}
+
+func (codec_1) Verify(config Config, data bitstr.BitString) error {
+  c, ok := config.(Config_1)
+  if !ok {
+    return ErrConfigMismatch
+  }
+  if sum := crc32.Checksum(rawData(data), castagnoli); sum != c.Checksum {
+    return fmt.Errorf("%w: expected 0x%08x, got 0x%08x", ErrChecksum, c.Checksum, sum)
+  }
+  return nil
+}
+
+func (codec_1) UpgradesFrom(version uint32) bool {
+  return version == 0x0
+}
@@ -0,0 +1,147 @@
+package digest
+
+import(
+  "github.com/pjrebsch/mizudiff/bitpos"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "errors"
+)
+
+// Consensus is the outcome of comparing many digests of the same artifact.
+type Consensus struct {
+  // Digest holds the window that most of the digests have at each position.
+  // Its length is the most common length among them.
+  Digest Digest
+
+  // Agreement counts, for each window of Digest, how many of the digests
+  // have that same window.
+  Agreement []int
+
+  // Outliers lists, for each digest in the order given, where it differs
+  // from the consensus, as with Spans.
+  Outliers [][]Span
+}
+
+// Unanimous returns how many windows every digest agrees on.
+func (c Consensus) Unanimous() int64 {
+  n := int64(0)
+  for _, a := range c.Agreement {
+    if a == len(c.Outliers) {
+      n++
+    }
+  }
+  return n
+}
+
+// NewConsensus votes on every window of the digests. Ties go to the window
+// of the earliest digest. All of the digests must have compatible versions
+// and share a window layout. The consensus has the version of the first.
+func NewConsensus(ds []Digest) (Consensus, error) {
+  if len(ds) == 0 {
+    return Consensus{}, errors.New("a consensus needs at least one digest")
+  }
+  for _, d := range ds[1:] {
+    if !Compatible(d.Version, ds[0].Version) {
+      return Consensus{}, ErrVersionMismatch
+    }
+    if d.Config.WindowSize() != ds[0].Config.WindowSize() ||
+      d.Config.AdvanceRate() != ds[0].Config.AdvanceRate() {
+      return Consensus{}, ErrConfigMismatch
+    }
+  }
+
+  length := commonLength(ds)
+  w := int64(ds[0].Config.WindowSize())
+  win := bitpos.New(0, w)
+  windows := length.CeilDividedBy(win).Int64()
+
+  l, err := length.CeilByteOffset()
+  if err != nil {
+    return Consensus{}, err
+  }
+  out := make([]byte, l)
+  agreement := make([]int, windows)
+
+  for j := int64(0); j < windows; j++ {
+    at := bitpos.New(0, j * w)
+
+    // Count the distinct windows in the order they're first seen, so that
+    // ties are broken by the earliest digest.
+    counts := map[string]int{}
+    order := []string{}
+    for _, d := range ds {
+      if d.Data.Length().Cmp(at.Int) <= 0 {
+        continue
+      }
+      s, err := d.Data.Slice(at, win)
+      if err != nil {
+        return Consensus{}, err
+      }
+      k := string(rawData(s))
+      if counts[k] == 0 {
+        order = append(order, k)
+      }
+      counts[k]++
+    }
+
+    best := ""
+    for _, k := range order {
+      if counts[k] > counts[best] {
+        best = k
+      }
+    }
+    agreement[j] = counts[best]
+    copyBits(out, j * w, []byte(best), w)
+  }
+
+  data := bitstr.New(out)
+  data.SetLength(length)
+  codec, err := Lookup(ds[0].Version)
+  if err != nil {
+    return Consensus{}, err
+  }
+  c, err := codec.NewConfig(data)
+  if err != nil {
+    return Consensus{}, err
+  }
+  consensus := Digest{ ds[0].Version, c, data }
+
+  outliers := make([][]Span, len(ds))
+  for i, d := range ds {
+    outliers[i], err = Spans(consensus, d)
+    if err != nil {
+      return Consensus{}, err
+    }
+  }
+
+  return Consensus{ consensus, agreement, outliers }, nil
+}
+
+// commonLength returns the most common data length among the digests,
+// preferring the longest when there's a tie.
+func commonLength(ds []Digest) bitpos.BitPosition {
+  counts := map[string]int{}
+  best := bitpos.Zero()
+  for _, d := range ds {
+    l := d.Data.Length()
+    counts[l.String()]++
+    n, m := counts[l.String()], counts[best.String()]
+    if n > m || n == m && l.Cmp(best.Int) > 0 {
+      best = l
+    }
+  }
+  return best
+}
+
+// copyBits copies the first `n` bits of `src` into `dst`, starting at bit
+// `at` of `dst`. The bits of `dst` must be zero to begin with.
+func copyBits(dst []byte, at int64, src []byte, n int64) {
+  for b := int64(0); b < n && b / bitpos.C < int64(len(src)); b++ {
+    if src[b / bitpos.C] & (0x80 >> uint(b % bitpos.C)) == 0 {
+      continue
+    }
+    p := at + b
+    if p / bitpos.C < int64(len(dst)) {
+      dst[p / bitpos.C] |= 0x80 >> uint(p % bitpos.C)
+    }
+  }
+}
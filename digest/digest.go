@@ -3,51 +3,98 @@ package digest
 import(
   "github.com/pjrebsch/mizudiff/bitpos"
   "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/logging"
+  "bytes"
+  "context"
   "encoding/binary"
-  "errors"
+  "fmt"
+  "io"
+  "log/slog"
 )
 
-const CurrentVersion = 0x0
-
-// Versions defines the valid versions and the expected byte length of their
-// configs.
-var Versions = map[uint32]uint16 {
-  0x0: 9,
-}
+// CurrentVersion is the version New writes digests in. Version 1 carries a
+// checksum of the data; version 0 digests still load and upgrade to it.
+const CurrentVersion = 0x1
 
 type Digest struct {
   Version uint32
-  Config interface{}
+  Config Config
   Data bitstr.BitString
 }
 
 type Config interface {
+  AdvanceRate() uint16
+  WindowSize() uint16
   DataLength() (bitpos.BitPosition, error)
 }
 
 func New(s bitstr.BitString) (Digest, error) {
-  c := Config_0{}
+  return NewContext(context.Background(), s, nil)
+}
+
+// NewContext is New, but gives up with the context's error once the context
+// is done and reports its progress through the source to `progress`, which
+// may be nil. It logs to the context's logger.
+func NewContext(ctx context.Context, s bitstr.BitString, progress bitstr.Progress) (Digest, error) {
+  return NewVersion(ctx, CurrentVersion, s, progress)
+}
+
+// NewVersion is NewContext for a digest of the given registered version.
+func NewVersion(ctx context.Context, version uint32, s bitstr.BitString, progress bitstr.Progress) (Digest, error) {
+  codec, err := Lookup(version)
+  if err != nil {
+    return Digest{}, err
+  }
+
+  data, err := codec.Compress(ctx, s, progress)
+  if err != nil {
+    return Digest{}, err
+  }
 
-  data, err := s.XORCompress(c.AdvanceRate(), c.WindowSize())
+  c, err := codec.NewConfig(data)
   if err != nil {
     return Digest{}, err
   }
 
-  l := data.Length()
-  c.ByteLength  = uint64(l.ByteOffset())
-  c.BitLength   = uint8(l.BitOffset())
+  if log := logging.FromContext(ctx); log.Enabled(ctx, slog.LevelDebug) {
+    log.Debug("digest created",
+      "version", version, "source_bits", s.Length().String(),
+      "digest_bits", data.Length().String())
+  }
 
-  return Digest{ CurrentVersion, c, data }, nil
+  return Digest{ version, c, data }, nil
 }
 
+// Load parses a serialized digest. It is lenient: anything after the digest
+// is ignored. Use LoadOptions to load untrusted data.
 func Load(raw []byte) (Digest, error) {
+  return LoadOptions{}.Load(raw)
+}
+
+// LoadOptions limits what Load accepts.
+type LoadOptions struct {
+  // MaxDataLength is the most digest data, in bytes, that the header may
+  // claim. Zero means there is no limit besides the length of the input.
+  MaxDataLength int64
+
+  // Strict rejects input that continues past the end of the digest, along
+  // with unused bits at the end of the data that aren't zero.
+  Strict bool
+
+  // Logger is where loading is logged. Nil logs nothing.
+  Logger *slog.Logger
+}
+
+// Load parses a serialized digest, checking the header against the options
+// before anything is allocated for the data.
+func (o LoadOptions) Load(raw []byte) (Digest, error) {
   version, err := getVersion(raw)
   if err != nil {
     return Digest{}, err
   }
 
   // Offset is initially set to the size of the version data.
-  offset := uint16(4)
+  offset := 4
 
   config, size, err := getConfig(version, raw[offset:])
   if err != nil {
@@ -56,66 +103,225 @@ func Load(raw []byte) (Digest, error) {
 
   offset += size
 
-  data, err := getData(config, raw[offset:])
+  data, err := o.getData(config, raw[offset:])
+  if err != nil {
+    return Digest{}, err
+  }
+  if err := verify(version, config, data); err != nil {
+    return Digest{}, err
+  }
+
+  o.logDigest("digest loaded", version, data)
+
+  return Digest{ version, config, data }, nil
+}
+
+// Read is Load for a digest that comes from `r`. Nothing past the end of the
+// digest is read, so `r` may go on to hold more.
+func Read(r io.Reader) (Digest, error) {
+  return LoadOptions{}.Read(r)
+}
+
+// Read is Load for a digest that comes from `r`. The header is read and
+// checked first, and then the data is read in chunks so that what's
+// allocated for it grows with what `r` actually holds. In strict mode, `r`
+// must end with the digest.
+func (o LoadOptions) Read(r io.Reader) (Digest, error) {
+  head := make([]byte, 4)
+  if err := readFull(r, head, "version info"); err != nil {
+    return Digest{}, err
+  }
+  version, err := getVersion(head)
+  if err != nil {
+    return Digest{}, err
+  }
+
+  codec, err := Lookup(version)
+  if err != nil {
+    return Digest{}, err
+  }
+  head = make([]byte, codec.ConfigSize())
+  if err := readFull(r, head, "config info"); err != nil {
+    return Digest{}, err
+  }
+  config, _, err := getConfig(version, head)
+  if err != nil {
+    return Digest{}, err
+  }
+
+  _, n, err := o.dataLength(config)
   if err != nil {
     return Digest{}, err
   }
+  var buf bytes.Buffer
+  for int64(buf.Len()) < n {
+    k := n - int64(buf.Len())
+    if k > readChunk {
+      k = readChunk
+    }
+    if _, err := io.CopyN(&buf, r, k); err != nil {
+      if err == io.EOF {
+        return Digest{},
+          fmt.Errorf("%w: configured length is greater than the actual data's length", ErrTruncated)
+      }
+      return Digest{}, err
+    }
+  }
+
+  if o.Strict {
+    var b [1]byte
+    _, err := io.ReadFull(r, b[:])
+    if err == nil {
+      return Digest{}, fmt.Errorf("%w: more bytes follow the digest", ErrTrailingData)
+    }
+    if err != io.EOF {
+      return Digest{}, err
+    }
+  }
+
+  data, err := o.getData(config, buf.Bytes())
+  if err != nil {
+    return Digest{}, err
+  }
+  if err := verify(version, config, data); err != nil {
+    return Digest{}, err
+  }
+
+  o.logDigest("digest read", version, data)
 
   return Digest{ version, config, data }, nil
 }
 
-// func Diff(a, b Digest) (bitstr.BitString, error) {
-//   if a.Version != b.Version {
-//     return s, errors.New("digest versions do not match")
-//   }
-// }
+// logDigest logs a digest that was loaded, if anything is listening.
+func (o LoadOptions) logDigest(msg string, version uint32, data bitstr.BitString) {
+  log := logging.OrDiscard(o.Logger)
+  if log.Enabled(context.Background(), slog.LevelDebug) {
+    log.Debug(msg, "version", version, "digest_bits", data.Length().String())
+  }
+}
+
+// readChunk is the most data that Read asks for at once.
+const readChunk = 1 << 16
+
+// readFull fills `b` from `r`, calling it truncated if `r` ends first.
+func readFull(r io.Reader, b []byte, what string) error {
+  _, err := io.ReadFull(r, b)
+  if err == io.EOF || err == io.ErrUnexpectedEOF {
+    return fmt.Errorf("%w: too short to contain %s", ErrTruncated, what)
+  }
+  return err
+}
+
+// Bytes serializes the digest in the format read by Load.
+func (d Digest) Bytes() ([]byte, error) {
+  config, err := putConfig(d.Version, d.Config)
+  if err != nil {
+    return nil, err
+  }
+
+  raw := make([]byte, 4, 4 + len(config))
+  binary.BigEndian.PutUint32(raw, d.Version)
+  raw = append(raw, config...)
+  raw = append(raw, rawData(d.Data)...)
+  return raw, nil
+}
+
+// rawData returns digest data as it is serialized and checksummed: MSB-first,
+// whatever order the bit string is in, so that Load reads back the same bits.
+func rawData(s bitstr.BitString) []byte {
+  return s.WithOrder(bitstr.MSBFirst).Bytes()
+}
+
+// Diff compares the data of two digests one window at a time, as with
+// bitstr.Diff. Both digests must have compatible versions for their windows
+// to line up.
+func Diff(a, b Digest) (bitstr.BitString, error) {
+  if !Compatible(a.Version, b.Version) {
+    return bitstr.BitString{}, ErrVersionMismatch
+  }
+  w := bitpos.New(0, int64(a.Config.WindowSize()))
+  diff, err := bitstr.Diff(a.Data, b.Data, w)
+  if err != nil {
+    return bitstr.BitString{}, err
+  }
+  return diff, nil
+}
 
 func getVersion(raw []byte) (uint32, error) {
   if len(raw) < 4 {
-    return 0, errors.New("digest data is too short to contain version info")
+    return 0, fmt.Errorf("%w: too short to contain version info", ErrTruncated)
   }
   return binary.BigEndian.Uint32(raw[:4]), nil
 }
 
-func getConfig(version uint32, raw []byte) (Config, uint16, error) {
-  size, ok := Versions[version]
-  if !ok {
-    return nil, size, errors.New("digest version is not recognized")
+func getConfig(version uint32, raw []byte) (Config, int, error) {
+  codec, err := Lookup(version)
+  if err != nil {
+    return nil, 0, err
   }
-  if len(raw) < int(size) {
+  size := codec.ConfigSize()
+  if len(raw) < size {
     return nil, size,
-      errors.New("digest data is too short to contain config info")
+      fmt.Errorf("%w: too short to contain config info", ErrTruncated)
   }
 
-  // Slice just where the config should exist. This will cause an error if
-  // and catch if the code tries to grab outside of where it should.
-  s := raw[:size]
+  // Slice just where the config should exist, so that the codec can't read
+  // past it.
+  c, err := codec.ParseConfig(raw[:size:size])
+  return c, size, err
+}
 
-  if version == 0x0 {
-    c := Config_0{}
-    c.ByteLength  = binary.BigEndian.Uint64(s[0:8])
-    c.BitLength   = uint8(s[8])
-    return c, size, nil
+func putConfig(version uint32, config Config) ([]byte, error) {
+  codec, err := Lookup(version)
+  if err != nil {
+    return nil, err
   }
-
-  return nil, size, errors.New("no config was defined in the source code")
+  return codec.EncodeConfig(config)
 }
 
-func getData(config Config, raw []byte) (bitstr.BitString, error) {
+// dataLength returns the length of the config's data in bits and in whole
+// bytes, checking it against the limit.
+func (o LoadOptions) dataLength(config Config) (bitpos.BitPosition, int64, error) {
   l, err := config.DataLength()
   if err != nil {
-    return bitstr.BitString{}, err
+    return bitpos.Zero(), 0, err
+  }
+  n, err := l.CeilByteOffset()
+  if err != nil {
+    return bitpos.Zero(), 0, err
   }
 
-  s := bitstr.New(raw)
+  if o.MaxDataLength > 0 && n > o.MaxDataLength {
+    return bitpos.Zero(), 0,
+      fmt.Errorf("%w: %d bytes of data is over the limit of %d", ErrTooLarge, n, o.MaxDataLength)
+  }
+  return l, n, nil
+}
+
+func (o LoadOptions) getData(config Config, raw []byte) (bitstr.BitString, error) {
+  l, n, err := o.dataLength(config)
+  if err != nil {
+    return bitstr.BitString{}, err
+  }
 
   // There is a problem (possibly an attempted denial of service) if the
   // configuration's length is greater than the actual length of the data.
-  if s.Length().Cmp(l.Int) == -1 {
+  if n > int64(len(raw)) {
+    return bitstr.BitString{},
+      fmt.Errorf("%w: configured length is greater than the actual data's length", ErrTruncated)
+  }
+  if o.Strict && n < int64(len(raw)) {
+    return bitstr.BitString{},
+      fmt.Errorf("%w: %d bytes follow the digest", ErrTrailingData, int64(len(raw)) - n)
+  }
+
+  // The unused bits are the low bits of the last byte.
+  if b := l.BitOffset(); o.Strict && b != 0 && raw[n-1] & (0xff >> uint(b)) != 0 {
     return bitstr.BitString{},
-      errors.New("configured length is greater than the actual data's length")
+      fmt.Errorf("%w: unused bits at the end of the data are set", ErrTrailingData)
   }
 
+  s := bitstr.New(raw[:n])
   s.SetLength(l)
   return s, nil
 }
@@ -2,8 +2,22 @@ package digest_test
 
 import(
   "bytes"
+  "context"
+  "encoding/binary"
+  "errors"
+  "flag"
+  "fmt"
+  "hash/crc32"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
   "testing"
+  "testing/iotest"
   "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/bitpos"
   "github.com/pjrebsch/mizudiff/bitstr"
 )
 
@@ -46,7 +60,7 @@ func TestNew(t *testing.T) {
       )
     }
 
-    c := d.Config.(digest.Config_0)
+    c := d.Config.(digest.Config_1)
 
     if c.ByteLength != uint64(l.ByteOffset()) {
       t.Errorf(
@@ -62,6 +76,14 @@ func TestNew(t *testing.T) {
       )
     }
 
+    sum := crc32.Checksum(data.Bytes(), crc32.MakeTable(crc32.Castagnoli))
+    if c.Checksum != sum {
+      t.Errorf(
+        "New(0x%02x): expected config checksum 0x%08x, got 0x%08x",
+        s.Bytes(), sum, c.Checksum,
+      )
+    }
+
     if !bytes.Equal(d.Data.Bytes(), data.Bytes()) {
       t.Errorf(
         "New(0x%02x): expected data %02x, got %02x",
@@ -71,16 +93,42 @@ func TestNew(t *testing.T) {
   }
 }
 
+func TestNewContext(t *testing.T) {
+  s := bitstr.New([]byte{0xf8, 0xac, 0x48, 0x6e, 0x0f, 0xda, 0x98, 0x69, 0x3c, 0x35})
+
+  done := int64(0)
+  d, err := digest.NewContext(context.Background(), s, func(n, total int64) {
+    done = n
+  })
+  if err != nil {
+    t.Fatalf("NewContext(): did not expect an error, but got one: %v", err)
+  }
+  if done != 10 {
+    t.Errorf("NewContext(): expected progress to end at 10 bytes, got %d", done)
+  }
+
+  expected, _ := digest.New(s)
+  if !reflect.DeepEqual(d, expected) {
+    t.Errorf("NewContext(): expected %v, got %v", expected, d)
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  if _, err := digest.NewContext(ctx, s, nil); !errors.Is(err, context.Canceled) {
+    t.Errorf("NewContext(): expected %v, got %v", context.Canceled, err)
+  }
+}
+
 func TestLoad(t *testing.T) {
   t.Run("version data can't be too short", func(t *testing.T) {
     raw := []byte{ 0x00, 0x00, 0x00 }
     _, err := digest.Load(raw)
 
-    expected := "digest data is too short to contain version info"
-    if err.Error() != expected {
+    expected := digest.ErrTruncated
+    if !errors.Is(err, expected) {
       t.Errorf(
-        "Load(0x%02x): expected %#v, but got %#v",
-        raw, expected, err.Error(),
+        "Load(0x%02x): expected %v, but got %v",
+        raw, expected, err,
       )
     }
   })
@@ -88,11 +136,11 @@ func TestLoad(t *testing.T) {
     raw := []byte{ 0x10, 0x00, 0x00, 0x00 }
     _, err := digest.Load(raw)
 
-    expected := "digest version is not recognized"
-    if err.Error() != expected {
+    expected := digest.ErrUnknownVersion
+    if !errors.Is(err, expected) {
       t.Errorf(
-        "Load(0x%02x): expected %#v, but got %#v",
-        raw, expected, err.Error(),
+        "Load(0x%02x): expected %v, but got %v",
+        raw, expected, err,
       )
     }
   })
@@ -100,11 +148,11 @@ func TestLoad(t *testing.T) {
     raw := []byte{ 0x00, 0x00, 0x00, 0x00 }
     _, err := digest.Load(raw)
 
-    expected := "digest data is too short to contain config info"
-    if err.Error() != expected {
+    expected := digest.ErrTruncated
+    if !errors.Is(err, expected) {
       t.Errorf(
-        "Load(0x%02x): expected %#v, but got %#v",
-        raw, expected, err.Error(),
+        "Load(0x%02x): expected %v, but got %v",
+        raw, expected, err,
       )
     }
   })
@@ -117,11 +165,28 @@ func TestLoad(t *testing.T) {
     }
     _, err := digest.Load(raw)
 
-    expected := "configured length is greater than the actual data's length"
-    if err.Error() != expected {
+    expected := digest.ErrTruncated
+    if !errors.Is(err, expected) {
       t.Errorf(
-        "Load(0x%02x): expected %#v, but got %#v",
-        raw, expected, err.Error(),
+        "Load(0x%02x): expected %v, but got %v",
+        raw, expected, err,
+      )
+    }
+  })
+  t.Run("bit length must be less than a byte", func(t *testing.T) {
+    raw := []byte{
+      0x00, 0x00, 0x00, 0x00,  // version
+      0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,  // byte length
+      0x08,  // bit length
+      0xff,  // data
+    }
+    _, err := digest.Load(raw)
+
+    expected := digest.ErrInvalidConfig
+    if !errors.Is(err, expected) {
+      t.Errorf(
+        "Load(0x%02x): expected %v, but got %v",
+        raw, expected, err,
       )
     }
   })
@@ -133,3 +198,783 @@ func TestLoad(t *testing.T) {
   //   { []byte{ 0x00, 0x00, 0x00, 0x00 }, digest.Digest{} },
   // }
 }
+
+func TestLoadOptions(t *testing.T) {
+  header := func(bytes byte, bits byte) []byte {
+    return []byte{
+      0x00, 0x00, 0x00, 0x00,  // version
+      0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, bytes,  // byte length
+      bits,  // bit length
+    }
+  }
+  huge := []byte{
+    0x00, 0x00, 0x00, 0x00,  // version
+    0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,  // byte length
+    0x00,  // bit length
+    0xff,  // data
+  }
+
+  var tbl = []struct {
+    opts digest.LoadOptions
+    raw []byte
+    err error
+  }{
+    { digest.LoadOptions{}, append(header(1, 0), 0xff, 0xee), nil },
+    { digest.LoadOptions{ Strict: true }, append(header(1, 0), 0xff), nil },
+    { digest.LoadOptions{ Strict: true }, append(header(1, 0), 0xff, 0xee), digest.ErrTrailingData },
+    { digest.LoadOptions{ Strict: true }, append(header(0, 4), 0xf0), nil },
+    { digest.LoadOptions{ Strict: true }, append(header(0, 4), 0xf8), digest.ErrTrailingData },
+    { digest.LoadOptions{}, append(header(0, 4), 0xf8), nil },
+    { digest.LoadOptions{ MaxDataLength: 1 }, append(header(1, 0), 0xff), nil },
+    { digest.LoadOptions{ MaxDataLength: 1 }, append(header(1, 1), 0xff, 0x80), digest.ErrTooLarge },
+    { digest.LoadOptions{ MaxDataLength: 1 << 20 }, huge, digest.ErrTooLarge },
+    { digest.LoadOptions{}, huge, digest.ErrTruncated },
+  }
+  for _, e := range tbl {
+    _, err := e.opts.Load(e.raw)
+    if e.err == nil && err != nil {
+      t.Errorf("%+v.Load(0x%02x): did not expect an error, but got one: %v", e.opts, e.raw, err)
+    }
+    if e.err != nil && !errors.Is(err, e.err) {
+      t.Errorf("%+v.Load(0x%02x): expected %v, but got %v", e.opts, e.raw, e.err, err)
+    }
+  }
+}
+
+func TestRead(t *testing.T) {
+  d, _ := digest.New(bitstr.New([]byte("the quick brown fox jumps over the lazy dog")))
+  raw, _ := d.Bytes()
+
+  t.Run("matches Load for every truncation", func(t *testing.T) {
+    for i := 0; i <= len(raw); i++ {
+      expected, lerr := digest.Load(raw[:i])
+      actual, rerr := digest.Read(iotest.OneByteReader(bytes.NewReader(raw[:i])))
+      if (lerr == nil) != (rerr == nil) || lerr != nil && !errors.Is(rerr, digest.ErrTruncated) {
+        t.Errorf("Read(%d bytes): expected %v, but got %v", i, lerr, rerr)
+        continue
+      }
+      if lerr != nil {
+        continue
+      }
+      a, _ := actual.Bytes()
+      e, _ := expected.Bytes()
+      if !bytes.Equal(a, e) {
+        t.Errorf("Read(%d bytes): expected 0x%02x, got 0x%02x", i, e, a)
+      }
+    }
+  })
+  t.Run("stops at the end of the digest", func(t *testing.T) {
+    r := bytes.NewReader(append(append([]byte{}, raw...), raw...))
+    for i := 0; i < 2; i++ {
+      if _, err := digest.Read(r); err != nil {
+        t.Fatalf("Read(): did not expect an error, but got one: %v", err)
+      }
+    }
+    if r.Len() != 0 {
+      t.Errorf("Read(): expected to read both digests, but %d bytes are left", r.Len())
+    }
+  })
+  t.Run("strict mode rejects trailing data", func(t *testing.T) {
+    r := bytes.NewReader(append(append([]byte{}, raw...), 0x00))
+    _, err := digest.LoadOptions{ Strict: true }.Read(r)
+    if !errors.Is(err, digest.ErrTrailingData) {
+      t.Errorf("Read(): expected %v, but got %v", digest.ErrTrailingData, err)
+    }
+  })
+  t.Run("limits the data length", func(t *testing.T) {
+    _, err := digest.LoadOptions{ MaxDataLength: 1 }.Read(bytes.NewReader(raw))
+    if !errors.Is(err, digest.ErrTooLarge) {
+      t.Errorf("Read(): expected %v, but got %v", digest.ErrTooLarge, err)
+    }
+  })
+  t.Run("passes on read errors", func(t *testing.T) {
+    failure := errors.New("failure")
+    r := io.MultiReader(bytes.NewReader(raw[:len(raw) - 1]), iotest.ErrReader(failure))
+    if _, err := digest.Read(r); !errors.Is(err, failure) {
+      t.Errorf("Read(): expected %v, but got %v", failure, err)
+    }
+  })
+}
+
+// testConfig and testCodec make an experimental version with windows of two
+// bytes that advance two bits at a time.
+type testConfig struct {
+  length int64
+}
+func (c testConfig) AdvanceRate() uint16 { return 2 }
+func (c testConfig) WindowSize() uint16 { return 16 }
+func (c testConfig) DataLength() (bitpos.BitPosition, error) {
+  return bitpos.New(0, c.length), nil
+}
+
+type testCodec struct{}
+
+func (testCodec) ConfigSize() int { return 8 }
+func (testCodec) ParseConfig(raw []byte) (digest.Config, error) {
+  return testConfig{ int64(binary.BigEndian.Uint64(raw)) }, nil
+}
+func (testCodec) EncodeConfig(c digest.Config) ([]byte, error) {
+  tc, ok := c.(testConfig)
+  if !ok {
+    return nil, digest.ErrConfigMismatch
+  }
+  return binary.BigEndian.AppendUint64(nil, uint64(tc.length)), nil
+}
+func (testCodec) NewConfig(data bitstr.BitString) (digest.Config, error) {
+  return testConfig{ data.Length().Int64() }, nil
+}
+func (testCodec) Compress(ctx context.Context, s bitstr.BitString, progress bitstr.Progress) (bitstr.BitString, error) {
+  return s.XORCompressContext(ctx, 2, 16, progress)
+}
+
+// experimentalVersion is registered by TestRegister, and isn't part of the
+// format.
+const experimentalVersion = 0x7e57
+
+func TestRegister(t *testing.T) {
+  const version = experimentalVersion
+  digest.Register(version, testCodec{})
+
+  if vs := digest.RegisteredVersions(); vs[0] != 0x0 || vs[len(vs) - 1] != version {
+    t.Errorf("RegisteredVersions(): expected 0 through %d, got %v", version, vs)
+  }
+  if digest.Versions[0x0] != 9 || digest.Versions[version] != uint16(testCodec{}.ConfigSize()) {
+    t.Errorf("Versions: expected the config sizes of the registered codecs, got %v", digest.Versions)
+  }
+
+  src := bitstr.New([]byte{ 0x12, 0x34, 0x56 })
+  d, err := digest.NewVersion(context.Background(), version, src, nil)
+  if err != nil {
+    t.Fatalf("NewVersion(): did not expect an error, but got one: %v", err)
+  }
+  expected, _ := src.XORCompress(2, 16)
+  if !bytes.Equal(d.Data.Bytes(), expected.Bytes()) || d.Data.Length().Cmp(expected.Length().Int) != 0 {
+    t.Errorf("NewVersion(): expected data 0x%02x, got 0x%02x", expected.Bytes(), d.Data.Bytes())
+  }
+
+  raw, err := d.Bytes()
+  if err != nil {
+    t.Fatalf("Bytes(): did not expect an error, but got one: %v", err)
+  }
+  loaded, err := digest.LoadOptions{ Strict: true }.Load(raw)
+  if err != nil {
+    t.Fatalf("Load(): did not expect an error, but got one: %v", err)
+  }
+  if loaded.Version != version || loaded.Config != d.Config {
+    t.Errorf("Load(): expected version %d with %v, got %d with %v",
+      version, d.Config, loaded.Version, loaded.Config)
+  }
+
+  t.Run("versions can't be registered twice", func(t *testing.T) {
+    defer func() {
+      if recover() == nil {
+        t.Errorf("Register(): expected a panic for a registered version")
+      }
+    }()
+    digest.Register(version, testCodec{})
+  })
+  t.Run("configs must belong to their version", func(t *testing.T) {
+    d.Version = digest.CurrentVersion
+    if _, err := d.Bytes(); !errors.Is(err, digest.ErrConfigMismatch) {
+      t.Errorf("Bytes(): expected %v, but got %v", digest.ErrConfigMismatch, err)
+    }
+  })
+  t.Run("unregistered versions are unknown", func(t *testing.T) {
+    if _, err := digest.Lookup(version + 1); !errors.Is(err, digest.ErrUnknownVersion) {
+      t.Errorf("Lookup(): expected %v, but got %v", digest.ErrUnknownVersion, err)
+    }
+  })
+}
+
+func TestUpgrade(t *testing.T) {
+  d0, _ := digest.New(bitstr.New([]byte("the quick brown fox jumps over the lazy dog")))
+
+  d1, err := digest.Upgrade(d0, 0x1)
+  if err != nil {
+    t.Fatalf("Upgrade(): did not expect an error, but got one: %v", err)
+  }
+  if d1.Version != 0x1 || !bytes.Equal(d1.Data.Bytes(), d0.Data.Bytes()) {
+    t.Errorf("Upgrade(): expected version 1 with the same data, got version %d with 0x%02x",
+      d1.Version, d1.Data.Bytes())
+  }
+  if dist, err := digest.Distance(d0, d1); err != nil || dist != 0 {
+    t.Errorf("Distance(): expected the upgraded digest to be identical, got %v, %v", dist, err)
+  }
+
+  if _, err := digest.Upgrade(d1, 0x0); !errors.Is(err, digest.ErrNotUpgradable) {
+    t.Errorf("Upgrade(): expected %v for a downgrade, but got %v", digest.ErrNotUpgradable, err)
+  }
+
+  t.Run("checksums are verified", func(t *testing.T) {
+    raw, _ := d1.Bytes()
+    raw[len(raw) - 1] ^= 0x80
+    if _, err := digest.Load(raw); !errors.Is(err, digest.ErrChecksum) {
+      t.Errorf("Load(): expected %v, but got %v", digest.ErrChecksum, err)
+    }
+    if _, err := digest.Read(bytes.NewReader(raw)); !errors.Is(err, digest.ErrChecksum) {
+      t.Errorf("Read(): expected %v, but got %v", digest.ErrChecksum, err)
+    }
+  })
+}
+
+// TestVersions checks every version against its digest of the same source
+// in testdata/versions, so that digests kept from before a change still
+// load, and upgrade, the same. Only a new version should need its golden
+// digest made with -update.
+func TestVersions(t *testing.T) {
+  src, err := ioutil.ReadFile(filepath.Join("testdata", "versions", "source"))
+  if err != nil {
+    t.Fatalf("ReadFile(): did not expect an error, but got one: %v", err)
+  }
+
+  golden := map[uint32][]byte{}
+  for _, v := range digest.RegisteredVersions() {
+    if v == experimentalVersion {
+      continue
+    }
+    // Digests written by earlier releases must keep loading, so -update
+    // only adds the golden digests of new versions.
+    p := filepath.Join("testdata", "versions", fmt.Sprintf("%d.digest", v))
+    if _, err := os.Stat(p); *update && os.IsNotExist(err) {
+      made, err := digest.NewVersion(context.Background(), v, bitstr.New(src), nil)
+      if err != nil {
+        t.Fatalf("NewVersion(%d): did not expect an error, but got one: %v", v, err)
+      }
+      raw, err := made.Bytes()
+      if err != nil {
+        t.Fatalf("Bytes(version %d): did not expect an error, but got one: %v", v, err)
+      }
+      if err := ioutil.WriteFile(p, raw, 0644); err != nil {
+        t.Fatalf("WriteFile(): did not expect an error, but got one: %v", err)
+      }
+    }
+    raw, err := ioutil.ReadFile(p)
+    if err != nil {
+      t.Fatalf("version %d: expected a golden digest: %v", v, err)
+    }
+    golden[v] = raw
+  }
+
+  for v, raw := range golden {
+    d, err := digest.LoadOptions{ Strict: true }.Load(raw)
+    if err != nil {
+      t.Errorf("Load(version %d): did not expect an error, but got one: %v", v, err)
+      continue
+    }
+    if d.Version != v {
+      t.Errorf("Load(version %d): got version %d", v, d.Version)
+    }
+    if b, _ := d.Bytes(); !bytes.Equal(b, raw) {
+      t.Errorf("Bytes(version %d): expected 0x%02x, got 0x%02x", v, raw, b)
+    }
+
+    made, err := digest.NewVersion(context.Background(), v, bitstr.New(src), nil)
+    if err != nil {
+      t.Errorf("NewVersion(%d): did not expect an error, but got one: %v", v, err)
+      continue
+    }
+    if b, _ := made.Bytes(); !bytes.Equal(b, raw) {
+      t.Errorf("NewVersion(%d): expected 0x%02x, got 0x%02x", v, raw, b)
+    }
+
+    for w, expected := range golden {
+      up, err := digest.Upgrade(d, w)
+      if errors.Is(err, digest.ErrNotUpgradable) {
+        continue
+      }
+      if err != nil {
+        t.Errorf("Upgrade(%d to %d): did not expect an error, but got one: %v", v, w, err)
+        continue
+      }
+      if b, _ := up.Bytes(); !bytes.Equal(b, expected) {
+        t.Errorf("Upgrade(%d to %d): expected 0x%02x, got 0x%02x", v, w, expected, b)
+      }
+    }
+  }
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata from the current output")
+
+// checkGolden compares `actual` with the golden file at testdata/`name`, or
+// rewrites the file with -update.
+func checkGolden(t *testing.T, name string, actual []byte) {
+  t.Helper()
+  p := filepath.Join("testdata", name)
+  if *update {
+    if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+      t.Fatalf("MkdirAll(): did not expect an error, but got one: %v", err)
+    }
+    if err := ioutil.WriteFile(p, actual, 0644); err != nil {
+      t.Fatalf("WriteFile(): did not expect an error, but got one: %v", err)
+    }
+    return
+  }
+
+  expected, err := ioutil.ReadFile(p)
+  if err != nil {
+    t.Errorf("%s: expected a golden file (run the tests with -update to make it): %v", name, err)
+    return
+  }
+  if !bytes.Equal(actual, expected) {
+    t.Errorf("%s: output has changed (run the tests with -update if that's intended)", name)
+  }
+}
+
+// goldenPairs are the inputs in testdata/corpus whose diffs are checked.
+var goldenPairs = [][2]string{
+  { "text", "text-edited" },
+  { "binary", "binary-flipped" },
+  { "binary", "binary-cut" },
+  { "zeros", "binary" },
+  { "empty", "text" },
+}
+
+// goldenCompressions are the settings at which the output of XORCompress
+// is checked, besides the digest's own.
+var goldenCompressions = []struct {
+  adv, win uint16
+}{
+  { 1, 8 },
+  { 3, 16 },
+  { 8, 64 },
+  { 64, 256 },
+}
+
+// TestGolden checks the digests of the inputs in testdata/corpus, their
+// XOR compression at other settings and the diffs between some of them
+// against the golden files in testdata/golden.
+func TestGolden(t *testing.T) {
+  paths, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.in"))
+  if err != nil || len(paths) == 0 {
+    t.Fatalf("Glob(): expected inputs in testdata/corpus, got %v, %v", paths, err)
+  }
+
+  ds := map[string]digest.Digest{}
+  for _, p := range paths {
+    name := strings.TrimSuffix(filepath.Base(p), ".in")
+    src, err := ioutil.ReadFile(p)
+    if err != nil {
+      t.Fatalf("ReadFile(): did not expect an error, but got one: %v", err)
+    }
+
+    d, err := digest.New(bitstr.New(src))
+    if err != nil {
+      t.Fatalf("New(%s): did not expect an error, but got one: %v", name, err)
+    }
+    raw, _ := d.Bytes()
+    checkGolden(t, filepath.Join("golden", name + ".digest"), raw)
+    ds[name] = d
+
+    var buf bytes.Buffer
+    for _, c := range goldenCompressions {
+      s, err := bitstr.New(src).XORCompress(c.adv, c.win)
+      if err != nil {
+        t.Fatalf("XORCompress(%s): did not expect an error, but got one: %v", name, err)
+      }
+      fmt.Fprintf(&buf, "adv %d win %d bits %v\n%x\n", c.adv, c.win, s.Length(), s.Bytes())
+    }
+    checkGolden(t, filepath.Join("golden", name + ".xor"), buf.Bytes())
+  }
+
+  for _, pair := range goldenPairs {
+    a, b := ds[pair[0]], ds[pair[1]]
+    diff, err := digest.Diff(a, b)
+    if err != nil {
+      t.Fatalf("Diff(%s, %s): did not expect an error, but got one: %v", pair[0], pair[1], err)
+    }
+    spans, err := digest.Spans(a, b)
+    if err != nil {
+      t.Fatalf("Spans(%s, %s): did not expect an error, but got one: %v", pair[0], pair[1], err)
+    }
+
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "windows %v differing %d\n%x\n", diff.Length(), diff.Count(), diff.Bytes())
+    for _, sp := range spans {
+      fmt.Fprintf(&buf, "bits %d-%d bytes %d-%d\n", sp.From, sp.To, sp.Source.Offset, sp.Source.End())
+    }
+    checkGolden(t, filepath.Join("golden", pair[0] + "_" + pair[1] + ".diff"), buf.Bytes())
+  }
+}
+
+func TestDiff(t *testing.T) {
+  t.Run("versions must match", func(t *testing.T) {
+    a, _ := digest.New(bitstr.New( []byte{ 0x01 } ))
+    b := a
+    b.Version = 0x10
+
+    _, err := digest.Diff(a, b)
+    if !errors.Is(err, digest.ErrVersionMismatch) {
+      t.Errorf("Diff(): expected %v, but got %v", digest.ErrVersionMismatch, err)
+    }
+  })
+
+  var tbl = []struct {
+    a, b, r []byte
+  }{
+    { []byte{}, []byte{}, []byte{} },
+    { []byte{0x12, 0x34}, []byte{0x12, 0x34}, []byte{0x00} },
+    { []byte{0x12, 0x34}, []byte{0x12, 0x35}, []byte{0x40} },
+    { []byte{0x12, 0x34}, []byte{0x13, 0x34}, []byte{0x80} },
+  }
+  for _, e := range tbl {
+    a, _ := digest.New(bitstr.New(e.a))
+    b, _ := digest.New(bitstr.New(e.b))
+
+    d, err := digest.Diff(a, b)
+    if err != nil {
+      t.Fatalf(
+        "Diff(0x%02x, 0x%02x): did not expect an error, but got one: %v",
+        e.a, e.b, err,
+      )
+    }
+    if !bytes.Equal(d.Bytes(), e.r) {
+      t.Errorf(
+        "Diff(0x%02x, 0x%02x): expected %08b, got %08b",
+        e.a, e.b, e.r, d.Bytes(),
+      )
+    }
+  }
+}
+
+func TestDistance(t *testing.T) {
+  var tbl = []struct {
+    a, b []byte
+    d float64
+  }{
+    { []byte{}, []byte{}, 0 },
+    { []byte{0x12, 0x34}, []byte{0x12, 0x34}, 0 },
+    { []byte{0x12, 0x34}, []byte{0x12, 0x35}, 0.5 },
+    { []byte{}, []byte{0x12, 0x34}, 1 },
+    { []byte{0x12}, []byte{0x12, 0x34}, 1 },
+    { []byte("00"), []byte("00\x00"), 0.5 },
+  }
+  for _, e := range tbl {
+    a, _ := digest.New(bitstr.New(e.a))
+    b, _ := digest.New(bitstr.New(e.b))
+
+    d, err := digest.Distance(a, b)
+    if err != nil {
+      t.Fatalf(
+        "Distance(0x%02x, 0x%02x): did not expect an error, but got one: %v",
+        e.a, e.b, err,
+      )
+    }
+    if d != e.d {
+      t.Errorf("Distance(0x%02x, 0x%02x): expected %v, got %v", e.a, e.b, e.d, d)
+    }
+  }
+}
+
+func TestRegions(t *testing.T) {
+  var tbl = []struct {
+    a, b []byte
+    r []digest.Region
+  }{
+    {
+      []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+      []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+      []digest.Region{},
+    }, {
+      // The change lands on digest bits 9-16, which spans two diff windows.
+      // Any of the last 9 source windows could have set those bits.
+      []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+      []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff},
+      []digest.Region{ {1, 9} },
+    }, {
+      []byte{0x00, 0x00},
+      []byte{0x00, 0x00, 0x00},
+      []digest.Region{ {2, 1} },
+    }, {
+      []byte{},
+      []byte{0x01, 0x02, 0x03},
+      []digest.Region{ {0, 3} },
+    },
+  }
+  for _, e := range tbl {
+    a, _ := digest.New(bitstr.New(e.a))
+    b, _ := digest.New(bitstr.New(e.b))
+
+    actual, err := digest.Regions(a, b)
+    if err != nil {
+      t.Fatalf(
+        "Regions(0x%02x, 0x%02x): did not expect an error, but got one: %v",
+        e.a, e.b, err,
+      )
+    }
+    if !reflect.DeepEqual(actual, e.r) {
+      t.Errorf(
+        "Regions(0x%02x, 0x%02x): expected %v, got %v",
+        e.a, e.b, e.r, actual,
+      )
+    }
+  }
+}
+
+func TestBytes(t *testing.T) {
+  t.Run("round trips through Load", func(t *testing.T) {
+    s := bitstr.New( []byte{0xf8, 0xac, 0x48, 0x6e, 0x0f, 0xda, 0x98, 0x69, 0x3c, 0x35} )
+    d, _ := digest.New(s)
+
+    raw, err := d.Bytes()
+    if err != nil {
+      t.Fatalf("Bytes(): did not expect an error, but got one: %v", err)
+    }
+
+    expected := []byte{
+      0x00, 0x00, 0x00, 0x01,  // version
+      0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,  // byte length
+      0x01,  // bit length
+      0x32, 0x1f, 0xea, 0xe0,  // checksum
+      0xb5, 0x74, 0x80,  // data
+    }
+    if !bytes.Equal(raw, expected) {
+      t.Errorf("Bytes(): expected 0x%02x, got 0x%02x", expected, raw)
+    }
+
+    l, err := digest.Load(raw)
+    if err != nil {
+      t.Fatalf("Load(0x%02x): did not expect an error, but got one: %v", raw, err)
+    }
+    if l.Version != d.Version || l.Config != d.Config || !bitstr.IsEqual(l.Data, d.Data) {
+      t.Errorf("Load(0x%02x): expected %v, got %v", raw, d, l)
+    }
+  })
+  t.Run("LSB-first data keeps its bits", func(t *testing.T) {
+    s := bitstr.NewOrder( []byte{0x1f, 0x35, 0x12, 0x76, 0xf0, 0x5b, 0x19, 0x96, 0x3c, 0xac}, bitstr.LSBFirst )
+    for _, version := range []uint32{ 0x0, 0x1 } {
+      d, err := digest.NewVersion(context.Background(), version, s, nil)
+      if err != nil {
+        t.Fatalf("NewVersion(%d): did not expect an error, but got one: %v", version, err)
+      }
+      raw, err := d.Bytes()
+      if err != nil {
+        t.Fatalf("Bytes(): did not expect an error, but got one: %v", err)
+      }
+
+      // The data is serialized MSB-first, as it would be for an MSB-first
+      // source with the same bits.
+      if !bytes.Equal(raw[len(raw)-3:], []byte{ 0xb5, 0x74, 0x80 }) {
+        t.Errorf("Bytes(version %d): expected the data 0xb57480, got 0x%02x", version, raw[len(raw)-3:])
+      }
+
+      l, err := digest.LoadOptions{ Strict: true }.Load(raw)
+      if err != nil {
+        t.Fatalf("Load(version %d): did not expect an error, but got one: %v", version, err)
+      }
+      if !bitstr.IsEqual(l.Data, d.Data) {
+        t.Errorf("Load(version %d): expected the bits %08b, got %08b", version,
+          d.Data.WithOrder(bitstr.MSBFirst).Bytes(), l.Data.Bytes())
+      }
+    }
+  })
+  t.Run("config must match the version", func(t *testing.T) {
+    d := digest.Digest{ 0x0, nil, bitstr.New([]byte{}) }
+
+    _, err := d.Bytes()
+    if !errors.Is(err, digest.ErrConfigMismatch) {
+      t.Errorf("Bytes(): expected %v, but got %v", digest.ErrConfigMismatch, err)
+    }
+  })
+}
+
+func TestSpans(t *testing.T) {
+  a := make([]byte, 40)
+  b := make([]byte, 41)
+  b[2] = 0x01
+  b[3] = 0x01
+  b[30] = 0x80
+
+  da, _ := digest.New(bitstr.New(a))
+  db, _ := digest.New(bitstr.New(b))
+
+  actual, err := digest.Spans(da, db)
+  if err != nil {
+    t.Fatalf("Spans(): did not expect an error, but got one: %v", err)
+  }
+
+  // Bytes 2 and 3 land on digest bits 9-10, byte 30 on digest bit 30. The
+  // extra byte makes the second digest one bit longer.
+  expected := []digest.Span{
+    { 8, 16, digest.Region{ 1, 15 } },
+    { 24, 32, digest.Region{ 17, 15 } },
+    { 47, 48, digest.Region{ 40, 1 } },
+  }
+  if !reflect.DeepEqual(actual, expected) {
+    t.Errorf("Spans(): expected %v, got %v", expected, actual)
+  }
+}
+
+func TestNewConsensus(t *testing.T) {
+  base := make([]byte, 64)
+  for i := range base {
+    base[i] = byte(i * 37)
+  }
+  flipped := append([]byte{}, base...)
+  flipped[20] ^= 0x01
+  longer := append(append([]byte{}, base...), 0xaa, 0xbb)
+
+  digests := func(ins ...[]byte) []digest.Digest {
+    ds := []digest.Digest{}
+    for _, in := range ins {
+      d, _ := digest.New(bitstr.New(in))
+      ds = append(ds, d)
+    }
+    return ds
+  }
+
+  t.Run("outvotes the odd digest out", func(t *testing.T) {
+    ds := digests(base, flipped, base, longer)
+    c, err := digest.NewConsensus(ds)
+    if err != nil {
+      t.Fatalf("NewConsensus(): did not expect an error, but got one: %v", err)
+    }
+
+    if !reflect.DeepEqual(c.Digest, ds[0]) {
+      t.Errorf("NewConsensus(): expected the consensus to be %v, got %v", ds[0], c.Digest)
+    }
+    if len(c.Agreement) != int(ds[0].Windows()) {
+      t.Errorf("NewConsensus(): expected %d windows, got %d", ds[0].Windows(), len(c.Agreement))
+    }
+    if u := c.Unanimous(); u == 0 || u >= ds[0].Windows() {
+      t.Errorf("NewConsensus(): expected some but not all windows to be unanimous, got %d", u)
+    }
+
+    for i, outlier := range []bool{ false, true, false, true } {
+      if (len(c.Outliers[i]) > 0) != outlier {
+        t.Errorf("NewConsensus(): expected digest %d to be an outlier: %v, got %v",
+          i, outlier, c.Outliers[i])
+      }
+    }
+    if r := c.Outliers[1][0].Source; r.Offset > 20 || r.End() <= 20 {
+      t.Errorf("NewConsensus(): expected the flipped byte to be in %v", r)
+    }
+  })
+
+  t.Run("breaks ties with the earliest digest", func(t *testing.T) {
+    ds := digests(flipped, base)
+    c, err := digest.NewConsensus(ds)
+    if err != nil {
+      t.Fatalf("NewConsensus(): did not expect an error, but got one: %v", err)
+    }
+    if !reflect.DeepEqual(c.Digest, ds[0]) {
+      t.Errorf("NewConsensus(): expected the consensus to be the first digest")
+    }
+  })
+
+  t.Run("versions must match", func(t *testing.T) {
+    ds := digests(base, base)
+    ds[1].Version = 0x10
+    if _, err := digest.NewConsensus(ds); !errors.Is(err, digest.ErrVersionMismatch) {
+      t.Errorf("NewConsensus(): expected %v, got %v", digest.ErrVersionMismatch, err)
+    }
+  })
+}
+
+func FuzzLoad(f *testing.F) {
+  for _, v := range digest.RegisteredVersions() {
+    d, _ := digest.NewVersion(context.Background(), v, bitstr.New([]byte{0xf8, 0xac, 0x48, 0x6e, 0x0f}), nil)
+    raw, _ := d.Bytes()
+    f.Add(raw)
+  }
+  f.Add([]byte{ 0x00, 0x00, 0x00, 0x00 })
+  f.Add([]byte{
+    0x00, 0x00, 0x00, 0x00,
+    0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+    0x03,
+    0xff, 0xff,
+  })
+
+  f.Fuzz(func(t *testing.T, raw []byte) {
+    d, err := digest.Load(raw)
+    if err != nil {
+      return
+    }
+
+    l, err := d.Config.DataLength()
+    if err != nil {
+      t.Fatalf("Load(): loaded a config without a valid length: %v", err)
+    }
+    if !bitpos.IsEqual(l, d.Data.Length()) {
+      t.Fatalf("Load(): config length %v doesn't match the data length %v", l, d.Data.Length())
+    }
+
+    codec, err := digest.Lookup(d.Version)
+    if err != nil {
+      t.Fatalf("Lookup(%d): loaded a digest of an unregistered version: %v", d.Version, err)
+    }
+    head := 4 + codec.ConfigSize()
+
+    out, err := d.Bytes()
+    if err != nil {
+      t.Fatalf("Bytes(): did not expect an error, but got one: %v", err)
+    }
+    if len(out) > len(raw) || !bytes.Equal(out[:head], raw[:head]) {
+      t.Fatalf("Bytes(): expected the header %02x, got %02x", raw[:head], out)
+    }
+
+    again, err := digest.Load(out)
+    if err != nil {
+      t.Fatalf("Load(Bytes()): did not expect an error, but got one: %v", err)
+    }
+    if !reflect.DeepEqual(again, d) {
+      t.Fatalf("Load(Bytes()): expected %v, got %v", d, again)
+    }
+
+    // Every digest has a single serialization, the one its version's codec
+    // would give it.
+    c, err := codec.NewConfig(d.Data)
+    if err != nil {
+      t.Fatalf("NewConfig(): did not expect an error, but got one: %v", err)
+    }
+    made := digest.Digest{ Version: d.Version, Config: c, Data: d.Data }
+    if canonical, _ := made.Bytes(); !bytes.Equal(canonical, out) {
+      t.Fatalf("Load(): expected the canonical form %02x, got %02x", canonical, out)
+    }
+  })
+}
+
+func FuzzDiff(f *testing.F) {
+  f.Add([]byte{0x12, 0x34}, []byte{0x12, 0x35})
+  f.Add([]byte{}, []byte{0xff, 0x00, 0xff})
+
+  f.Fuzz(func(t *testing.T, a, b []byte) {
+    if len(a) > 1 << 10 || len(b) > 1 << 10 {
+      t.Skip()
+    }
+    da, _ := digest.New(bitstr.New(a))
+    db, _ := digest.New(bitstr.New(b))
+
+    dist, err := digest.Distance(da, db)
+    if err != nil {
+      t.Fatalf("Distance(): did not expect an error, but got one: %v", err)
+    }
+    if dist < 0 || dist > 1 {
+      t.Fatalf("Distance(): expected a fraction, got %v", dist)
+    }
+    if self, _ := digest.Distance(da, da); self != 0 {
+      t.Fatalf("Distance(): expected a digest to be 0 from itself, got %v", self)
+    }
+    rs, err := digest.Regions(da, db)
+    if err != nil {
+      t.Fatalf("Regions(): did not expect an error, but got one: %v", err)
+    }
+    longest := int64(len(a))
+    if int64(len(b)) > longest {
+      longest = int64(len(b))
+    }
+    for i, r := range rs {
+      if r.Length <= 0 || r.Offset < 0 || r.End() > longest {
+        t.Fatalf("Regions(): %v is outside of the %d source bytes", r, longest)
+      }
+      if i > 0 && r.Offset <= rs[i-1].End() {
+        t.Fatalf("Regions(): %v isn't after %v", r, rs[i-1])
+      }
+    }
+
+    // Windows can collide, so a difference in the sources doesn't always
+    // show up, but every differing window does.
+    if (len(rs) > 0) != (dist > 0) {
+      t.Fatalf("Regions(): found %v at a distance of %v", rs, dist)
+    }
+  })
+}
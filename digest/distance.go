@@ -0,0 +1,46 @@
+package digest
+
+import(
+  "github.com/pjrebsch/mizudiff/bitpos"
+)
+
+// Windows returns how many windows the digest's data spans, counting a
+// partial window at the end.
+func (d Digest) Windows() int64 {
+  w := bitpos.New(0, int64(d.Config.WindowSize()))
+  return d.Data.Length().CeilDividedBy(w).Int64()
+}
+
+// Distance returns the fraction of windows that differ between two digests,
+// from 0 for identical digests to 1. Windows that only the longer digest
+// has count as differing, and two empty digests are identical.
+func Distance(a, b Digest) (float64, error) {
+  diff, err := Diff(a, b)
+  if err != nil {
+    return 0, err
+  }
+
+  n := a.Windows()
+  if m := b.Windows(); m > n {
+    n = m
+  }
+  if n == 0 {
+    return 0, nil
+  }
+  differing := diff.Count() + n - diff.Length().Int64()
+
+  // A shorter digest that ends partway through a window makes that window
+  // differ too, as it does for Spans.
+  la, lb := a.Data.Length(), b.Data.Length()
+  if !bitpos.IsEqual(la, lb) {
+    w := bitpos.New(0, int64(a.Config.WindowSize()))
+    shortest := bitpos.Min(la, lb)
+    last := shortest.DividedBy(w)
+    if last.Cmp(diff.Length().Int) < 0 {
+      if set, err := diff.Bit(last); err == nil && !set {
+        differing++
+      }
+    }
+  }
+  return float64(differing) / float64(n), nil
+}
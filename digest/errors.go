@@ -0,0 +1,45 @@
+package digest
+
+import(
+  "errors"
+)
+
+var (
+  // ErrTruncated is returned when serialized digest data ends before
+  // everything it describes.
+  ErrTruncated = errors.New("digest data is truncated")
+
+  // ErrUnknownVersion is returned for a version without a config.
+  ErrUnknownVersion = errors.New("digest version is not recognized")
+
+  // ErrOverflow is returned when a config describes more data than can be
+  // represented.
+  ErrOverflow = errors.New("digest length overflows int64")
+
+  // ErrVersionMismatch is returned when digests of different versions are
+  // compared.
+  ErrVersionMismatch = errors.New("digest versions do not match")
+
+  // ErrTooLarge is returned when a digest claims more data than is allowed.
+  ErrTooLarge = errors.New("digest data is larger than allowed")
+
+  // ErrTrailingData is returned when strictly loading input that holds more
+  // than the digest.
+  ErrTrailingData = errors.New("digest is followed by trailing data")
+
+  // ErrInvalidConfig is returned when a serialized config holds values that
+  // its version doesn't allow.
+  ErrInvalidConfig = errors.New("digest config is invalid")
+
+  // ErrChecksum is returned when a digest's data doesn't match the
+  // checksum in its config.
+  ErrChecksum = errors.New("digest data does not match its checksum")
+
+  // ErrNotUpgradable is returned when a digest can't be upgraded to a
+  // version without its source.
+  ErrNotUpgradable = errors.New("digest can't be upgraded without its source")
+
+  // ErrConfigMismatch is returned when a digest's config doesn't belong to
+  // its version.
+  ErrConfigMismatch = errors.New("digest config does not match its version")
+)
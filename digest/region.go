@@ -0,0 +1,138 @@
+package digest
+
+import(
+  "github.com/pjrebsch/mizudiff/bitpos"
+)
+
+// Region is a span of source bytes.
+type Region struct {
+  Offset int64
+  Length int64
+}
+
+// End returns the offset just past the last byte of the region.
+func (r Region) End() int64 {
+  return r.Offset + r.Length
+}
+
+// Span pairs a run of differing digest bits, [From, To), with the source
+// bytes that could have caused it.
+type Span struct {
+  From, To int64
+  Source Region
+}
+
+// Spans compares two digests and returns every run of differing windows,
+// in order. When the digests differ in length, everything past the end of
+// the shorter one is reported as a final span.
+func Spans(a, b Digest) ([]Span, error) {
+  diff, err := Diff(a, b)
+  if err != nil {
+    return nil, err
+  }
+
+  adv := bitpos.New(0, int64(a.Config.AdvanceRate()))
+  win := bitpos.New(0, int64(a.Config.WindowSize()))
+  one := bitpos.New(0, 1)
+
+  la, lb := a.Data.Length(), b.Data.Length()
+  longest := bitpos.Max(la, lb)
+
+  ss := []Span{}
+
+  // The first diff window of the current run, if there is one.
+  var run *bitpos.BitPosition
+
+  end := diff.Length()
+  for i := bitpos.Zero(); i.Cmp(end.Int) <= 0; i = i.Plus(one) {
+    set := false
+    if i.Cmp(end.Int) < 0 {
+      set, err = diff.Bit(i)
+      if err != nil {
+        return nil, err
+      }
+    }
+
+    if set && run == nil {
+      from := i
+      run = &from
+    }
+    if !set && run != nil {
+      // Diff windows are as wide as the digest's windows.
+      from := run.MultipliedBy(win)
+      to := bitpos.Min(i.MultipliedBy(win), longest)
+      ss, err = appendSpan(ss, from, to, longest, adv, win)
+      if err != nil {
+        return nil, err
+      }
+      run = nil
+    }
+  }
+
+  if !bitpos.IsEqual(la, lb) {
+    ss, err = appendSpan(ss, bitpos.Min(la, lb), longest, longest, adv, win)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  return ss, nil
+}
+
+// Regions compares two digests and translates every differing window into
+// the span of source bytes that could have caused it. Spans that touch or
+// overlap are merged. When the digests differ in length, everything past
+// the end of the shorter one is reported as well.
+func Regions(a, b Digest) ([]Region, error) {
+  ss, err := Spans(a, b)
+  if err != nil {
+    return nil, err
+  }
+  return MergeSpans(ss), nil
+}
+
+// MergeSpans returns the source regions of spans from Spans, merging those
+// that touch or overlap.
+func MergeSpans(ss []Span) []Region {
+  rs := []Region{}
+  for _, s := range ss {
+    r := s.Source
+    if r.Length == 0 {
+      continue
+    }
+    if n := len(rs); n > 0 && r.Offset <= rs[n-1].End() {
+      if r.End() > rs[n-1].End() {
+        rs[n-1].Length = r.End() - rs[n-1].Offset
+      }
+      continue
+    }
+    rs = append(rs, r)
+  }
+  return rs
+}
+
+// appendSpan adds the digest bits [from, to) of a digest that is `length`
+// bits long, along with the source bytes of every window that was folded
+// into them.
+func appendSpan(ss []Span, from, to, length, adv, win bitpos.BitPosition) ([]Span, error) {
+  one := bitpos.New(0, 1)
+
+  // Window k is folded into the digest bits [k*adv, k*adv + win), so it
+  // overlaps [from, to) when (from - win)/adv < k <= (to - 1)/adv.
+  first := bitpos.Max(bitpos.Zero(), from.Minus(win).DividedBy(adv).Plus(one))
+  last := bitpos.Min(to.Minus(one).DividedBy(adv),
+    length.Minus(win).DividedBy(adv))
+
+  s := Span{ From: from.Int64(), To: to.Int64() }
+
+  if first.Cmp(last.Int) <= 0 {
+    start := first.MultipliedBy(win).ByteOffset()
+    end, err := last.Plus(one).MultipliedBy(win).CeilByteOffset()
+    if err != nil {
+      return nil, err
+    }
+    s.Source = Region{ start, end - start }
+  }
+
+  return append(ss, s), nil
+}
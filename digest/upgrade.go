@@ -0,0 +1,73 @@
+package digest
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "fmt"
+)
+
+// Verifier is implemented by codecs whose configs can vouch for their
+// data, as with a checksum. Load and Read call Verify on what they load.
+type Verifier interface {
+  Verify(c Config, data bitstr.BitString) error
+}
+
+// Upgrader is implemented by codecs that can take the data of digests of
+// earlier versions as it is, without going back to the source.
+type Upgrader interface {
+  UpgradesFrom(version uint32) bool
+}
+
+// Upgrade returns the digest as the target version, keeping its data. Only
+// upgrades that the target's codec allows are lossless; anything else needs
+// a new digest from the source and returns ErrNotUpgradable.
+func Upgrade(d Digest, target uint32) (Digest, error) {
+  if d.Version == target {
+    return d, nil
+  }
+  if !upgrades(d.Version, target) {
+    return Digest{},
+      fmt.Errorf("%w: from 0x%x to 0x%x", ErrNotUpgradable, d.Version, target)
+  }
+
+  codec, err := Lookup(target)
+  if err != nil {
+    return Digest{}, err
+  }
+  c, err := codec.NewConfig(d.Data)
+  if err != nil {
+    return Digest{}, err
+  }
+  return Digest{ target, c, d.Data }, nil
+}
+
+// Compatible reports whether digests of the two versions have windows that
+// line up, so that they can be compared: they are the same version, or one
+// upgrades to the other.
+func Compatible(a, b uint32) bool {
+  return a == b || upgrades(a, b) || upgrades(b, a)
+}
+
+func upgrades(from, to uint32) bool {
+  if _, err := Lookup(from); err != nil {
+    return false
+  }
+  codec, err := Lookup(to)
+  if err != nil {
+    return false
+  }
+  u, ok := codec.(Upgrader)
+  return ok && u.UpgradesFrom(from)
+}
+
+// verify checks the data against the config, if the version's codec is a
+// Verifier.
+func verify(version uint32, c Config, data bitstr.BitString) error {
+  codec, err := Lookup(version)
+  if err != nil {
+    return err
+  }
+  if v, ok := codec.(Verifier); ok {
+    return v.Verify(c, data)
+  }
+  return nil
+}
@@ -0,0 +1,191 @@
+package eval
+
+import(
+  "github.com/pjrebsch/mizudiff/bitpos"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "errors"
+  "fmt"
+  "math"
+  "strconv"
+  "strings"
+)
+
+// Config is a window layout to evaluate: how far each window advances and
+// how wide it is, in bits, as with XORCompress.
+type Config struct {
+  Advance, Window uint16
+}
+
+func (c Config) String() string {
+  return fmt.Sprintf("%d:%d", c.Advance, c.Window)
+}
+
+// ParseConfigs reads a comma-separated list of layouts, each written as
+// "advance:window".
+func ParseConfigs(list string) ([]Config, error) {
+  cs := []Config{}
+  for _, s := range strings.Split(list, ",") {
+    parts := strings.Split(s, ":")
+    if len(parts) != 2 {
+      return nil, errors.New("config is not advance:window: " + s)
+    }
+    adv, err := strconv.ParseUint(parts[0], 10, 16)
+    if err != nil {
+      return nil, fmt.Errorf("config %s: %w", s, err)
+    }
+    win, err := strconv.ParseUint(parts[1], 10, 16)
+    if err != nil {
+      return nil, fmt.Errorf("config %s: %w", s, err)
+    }
+    if adv == 0 || win == 0 {
+      return nil, errors.New("config must have a nonzero advance and window: " + s)
+    }
+    cs = append(cs, Config{ uint16(adv), uint16(win) })
+  }
+  return cs, nil
+}
+
+// layoutVersion is the version of the digests that Regions makes for a
+// layout. It is never registered, so they can't be serialized, and
+// digest.Compatible only pairs them with each other, never with real
+// digests whose windows may not line up.
+const layoutVersion = math.MaxUint32
+
+// layout describes the data of a digest made for a Config.
+type layout struct {
+  Config
+  length bitpos.BitPosition
+}
+func (l layout) AdvanceRate() uint16 {
+  return l.Advance
+}
+func (l layout) WindowSize() uint16 {
+  return l.Window
+}
+func (l layout) DataLength() (bitpos.BitPosition, error) {
+  return l.length, nil
+}
+
+// digest XOR compresses `src` with the config into a digest of its own,
+// unregistered version.
+func (c Config) digest(src []byte) (digest.Digest, error) {
+  data, err := bitstr.Wrap(src).XORCompress(c.Advance, c.Window)
+  if err != nil {
+    return digest.Digest{}, err
+  }
+  return digest.Digest{ Version: layoutVersion, Config: layout{ c, data.Length() }, Data: data }, nil
+}
+
+// Regions XOR compresses `a` and `b` with the config and returns the
+// regions of `b` that could have caused the windows that differ. They come
+// from digest.Regions, so they are what the diff command would report if
+// digests used this layout.
+func (c Config) Regions(a, b []byte) ([]digest.Region, error) {
+  da, err := c.digest(a)
+  if err != nil {
+    return nil, err
+  }
+  db, err := c.digest(b)
+  if err != nil {
+    return nil, err
+  }
+  return digest.Regions(da, db)
+}
+
+// Score counts bytes to measure how well the regions found by a diff match
+// the regions that really changed.
+type Score struct {
+  Detected int64  // bytes the diff reported
+  Changed int64   // bytes that really changed
+  Correct int64   // bytes that were both
+}
+
+// Add sums two scores, so that precision and recall can be taken over many
+// trials.
+func (s Score) Add(o Score) Score {
+  return Score{ s.Detected + o.Detected, s.Changed + o.Changed, s.Correct + o.Correct }
+}
+
+// Precision is the fraction of the reported bytes that really changed. A
+// diff that reports nothing is precise.
+func (s Score) Precision() float64 {
+  if s.Detected == 0 {
+    return 1
+  }
+  return float64(s.Correct) / float64(s.Detected)
+}
+
+// Recall is the fraction of the changed bytes that the diff reported.
+// Nothing to find is found entirely.
+func (s Score) Recall() float64 {
+  if s.Changed == 0 {
+    return 1
+  }
+  return float64(s.Correct) / float64(s.Changed)
+}
+
+// Evaluate scores the regions of `b`, a mutation of `a`, that Regions finds
+// against `truth`.
+func Evaluate(c Config, a, b []byte, truth []digest.Region) (Score, error) {
+  found, err := c.Regions(a, b)
+  if err != nil {
+    return Score{}, err
+  }
+
+  // Regions can reach past the end of the shorter input.
+  for i := range found {
+    if end := found[i].End(); end > int64(len(b)) {
+      found[i].Length -= end - int64(len(b))
+    }
+  }
+
+  s := Score{ Detected: total(found), Changed: total(truth) }
+  for _, f := range found {
+    for _, t := range truth {
+      lo, hi := f.Offset, f.End()
+      if t.Offset > lo {
+        lo = t.Offset
+      }
+      if t.End() < hi {
+        hi = t.End()
+      }
+      if hi > lo {
+        s.Correct += hi - lo
+      }
+    }
+  }
+  return s, nil
+}
+
+// total returns the number of bytes in the regions, which must not overlap.
+func total(rs []digest.Region) int64 {
+  n := int64(0)
+  for _, r := range rs {
+    if r.Length > 0 {
+      n += r.Length
+    }
+  }
+  return n
+}
+
+// Trials mutates `src` `trials` times with `n` mutations each and scores
+// every config on the same mutations. It returns each config's scores
+// summed over the trials.
+func Trials(m Mutator, src []byte, n, trials int, cs []Config) ([]Score, error) {
+  if err := m.check(); err != nil {
+    return nil, err
+  }
+  scores := make([]Score, len(cs))
+  for i := 0; i < trials; i++ {
+    b, _, truth := m.Mutate(src, n)
+    for j, c := range cs {
+      s, err := Evaluate(c, src, b, truth)
+      if err != nil {
+        return nil, err
+      }
+      scores[j] = scores[j].Add(s)
+    }
+  }
+  return scores, nil
+}
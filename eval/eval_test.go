@@ -0,0 +1,132 @@
+package eval_test
+
+import(
+  "bytes"
+  "math/rand"
+  "reflect"
+  "testing"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/eval"
+)
+
+func TestMutate(t *testing.T) {
+  src := make([]byte, 256)
+  rand.New(rand.NewSource(7)).Read(src)
+
+  for _, k := range eval.Kinds {
+    m := eval.Mutator{ Rand: rand.New(rand.NewSource(1)), Kinds: []eval.Kind{ k }, MaxLength: 8 }
+    out, ms, rs := m.Mutate(src, 1)
+    if len(ms) != 1 || len(rs) == 0 {
+      t.Fatalf("Mutate(%v): expected a mutation with changed regions, got %v and %v", k, ms, rs)
+    }
+    mu := ms[0]
+
+    expected := int64(len(src))
+    switch k {
+    case eval.Insert:
+      expected += mu.Length
+    case eval.Delete:
+      expected -= mu.Length
+    }
+    if int64(len(out)) != expected {
+      t.Errorf("Mutate(%v): expected %d bytes, got %d", k, expected, len(out))
+    }
+
+    // Everything outside of the changed regions is as it was, for the
+    // mutations that don't move anything.
+    if k == eval.ByteFlip || k == eval.BitFlip {
+      r := rs[0]
+      if len(rs) != 1 || r.Length != 1 || out[r.Offset] == src[r.Offset] {
+        t.Errorf("Mutate(%v): expected one changed byte, got %v", k, rs)
+      }
+      if !bytes.Equal(out[:r.Offset], src[:r.Offset]) || !bytes.Equal(out[r.End():], src[r.End():]) {
+        t.Errorf("Mutate(%v): expected the other bytes to be unchanged", k)
+      }
+    }
+    if k == eval.Insert && !reflect.DeepEqual(rs, []digest.Region{ { Offset: mu.Offset, Length: mu.Length } }) {
+      t.Errorf("Mutate(%v): expected the inserted run %+v to be changed, got %v", k, mu, rs)
+    }
+  }
+
+  // Moves always go somewhere else, so what they mark as changed is.
+  m := eval.Mutator{ Rand: rand.New(rand.NewSource(5)), Kinds: []eval.Kind{ eval.Move }, MaxLength: 4 }
+  for i := 0; i < 100; i++ {
+    _, ms, _ := m.Mutate(src[:8], 1)
+    for _, mu := range ms {
+      if mu.To == mu.Offset {
+        t.Fatalf("Mutate(move): expected the run to move, got %+v", mu)
+      }
+    }
+  }
+
+  for _, bad := range []eval.Mutator{
+    { Rand: rand.New(rand.NewSource(1)), MaxLength: 8 },
+    { Rand: rand.New(rand.NewSource(1)), Kinds: eval.Kinds },
+  } {
+    if _, err := eval.Trials(bad, src, 1, 1, []eval.Config{ { 1, 8 } }); err == nil {
+      t.Errorf("Trials(%v, %d): expected an error", bad.Kinds, bad.MaxLength)
+    }
+  }
+
+  if _, err := eval.ParseKinds("byte,nope"); err == nil {
+    t.Errorf("ParseKinds(): expected an error for an unknown kind")
+  }
+}
+
+func TestEvaluate(t *testing.T) {
+  a := make([]byte, 512)
+  rand.New(rand.NewSource(3)).Read(a)
+  b := append([]byte{}, a...)
+  b[200] ^= 0xff
+  truth := []digest.Region{ { Offset: 200, Length: 1 } }
+
+  cs, err := eval.ParseConfigs("1:8,8:64")
+  if err != nil {
+    t.Fatalf("ParseConfigs(): did not expect an error, but got one: %v", err)
+  }
+  for _, c := range cs {
+    s, err := eval.Evaluate(c, a, b, truth)
+    if err != nil {
+      t.Fatalf("Evaluate(%v): did not expect an error, but got one: %v", c, err)
+    }
+    if s.Recall() != 1 || s.Changed != 1 {
+      t.Errorf("Evaluate(%v): expected the changed byte to be found, got %+v", c, s)
+    }
+    if s.Precision() <= 0 || s.Precision() > 1 {
+      t.Errorf("Evaluate(%v): expected a precision in (0, 1], got %v", c, s.Precision())
+    }
+  }
+
+  // Wider windows can't be more precise about a single byte.
+  narrow, _ := eval.Evaluate(cs[0], a, b, truth)
+  wide, _ := eval.Evaluate(cs[1], a, b, truth)
+  if wide.Precision() > narrow.Precision() {
+    t.Errorf("Evaluate(): expected %v to be at least as precise as %v", cs[0], cs[1])
+  }
+
+  // With the layout of digests, the regions are those of digests.
+  m := eval.Mutator{ Rand: rand.New(rand.NewSource(9)), Kinds: eval.Kinds, MaxLength: 16 }
+  for i := 0; i < 20; i++ {
+    mutated, _, _ := m.Mutate(a, 3)
+    found, err := eval.Config{ Advance: 1, Window: 8 }.Regions(a, mutated)
+    if err != nil {
+      t.Fatalf("Regions(): did not expect an error, but got one: %v", err)
+    }
+    da, _ := digest.New(bitstr.Wrap(a))
+    db, _ := digest.New(bitstr.Wrap(mutated))
+    expected, err := digest.Regions(da, db)
+    if err != nil {
+      t.Fatalf("Regions(): did not expect an error, but got one: %v", err)
+    }
+    if !reflect.DeepEqual(found, expected) {
+      t.Errorf("Regions(#%d): expected the digests' regions %v, got %v", i, expected, found)
+    }
+  }
+
+  for _, bad := range []string{ "1", "0:8", "1:x" } {
+    if _, err := eval.ParseConfigs(bad); err == nil {
+      t.Errorf("ParseConfigs(%q): expected an error", bad)
+    }
+  }
+}
@@ -0,0 +1,203 @@
+package eval
+
+import(
+  "github.com/pjrebsch/mizudiff/digest"
+  "errors"
+  "fmt"
+  "math/rand"
+  "strings"
+)
+
+// Kind is a kind of mutation.
+type Kind int
+
+const (
+  ByteFlip Kind = iota  // a byte replaced with a different one
+  BitFlip               // a single bit flipped
+  Insert                // a run of new bytes inserted
+  Delete                // a run of bytes deleted
+  Move                  // a run of bytes moved elsewhere
+)
+
+var kindNames = map[Kind]string {
+  ByteFlip: "byte",
+  BitFlip: "bit",
+  Insert: "insert",
+  Delete: "delete",
+  Move: "move",
+}
+
+// Kinds lists every kind of mutation.
+var Kinds = []Kind{ ByteFlip, BitFlip, Insert, Delete, Move }
+
+func (k Kind) String() string {
+  if name, ok := kindNames[k]; ok {
+    return name
+  }
+  return "unknown"
+}
+
+// ParseKinds returns the kinds named in a comma-separated list.
+func ParseKinds(list string) ([]Kind, error) {
+  ks := []Kind{}
+  for _, name := range strings.Split(list, ",") {
+    found := false
+    for _, k := range Kinds {
+      if kindNames[k] == name {
+        ks = append(ks, k)
+        found = true
+      }
+    }
+    if !found {
+      return nil, errors.New("mutation kind is not recognized: " + name)
+    }
+  }
+  return ks, nil
+}
+
+// Mutation is one change made to an input. Offsets are into the input as
+// it was when the mutation was made.
+type Mutation struct {
+  Kind Kind
+  Offset int64
+  Length int64
+  To int64  // where a moved run ends up, once it has been cut out
+}
+
+// Mutator makes random mutations. Its Kinds must not be empty and its
+// MaxLength must be at least 1.
+type Mutator struct {
+  Rand *rand.Rand
+
+  // Kinds are the kinds of mutation to choose from.
+  Kinds []Kind
+
+  // MaxLength is the longest run that is inserted, deleted or moved.
+  MaxLength int64
+}
+
+// check reports a mutator that can't make any mutations.
+func (m Mutator) check() error {
+  if len(m.Kinds) == 0 {
+    return errors.New("mutator has no kinds of mutation to choose from")
+  }
+  if m.MaxLength < 1 {
+    return fmt.Errorf("mutator max length must be at least 1, not %d", m.MaxLength)
+  }
+  return nil
+}
+
+// Mutate applies `n` random mutations to a copy of `src`. Along with the
+// copy, it returns the mutations and the regions of the copy that they
+// changed. Inserted and moved bytes are changed, as is the byte that
+// follows a deletion, or precedes it at the end. It panics if `n` is
+// positive and the mutator has no Kinds or a MaxLength below 1; Trials
+// returns an error instead.
+func (m Mutator) Mutate(src []byte, n int) ([]byte, []Mutation, []digest.Region) {
+  out := append([]byte{}, src...)
+  changed := make([]bool, len(out))
+  ms := []Mutation{}
+
+  for i := 0; i < n; i++ {
+    mu := m.next(int64(len(out)))
+    if mu.Length == 0 && mu.Kind != Insert {
+      continue
+    }
+    out, changed = apply(out, changed, mu, m.Rand)
+    ms = append(ms, mu)
+  }
+
+  rs := []digest.Region{}
+  for i := 0; i < len(changed); i++ {
+    if !changed[i] {
+      continue
+    }
+    j := i
+    for j < len(changed) && changed[j] {
+      j++
+    }
+    rs = append(rs, digest.Region{ Offset: int64(i), Length: int64(j - i) })
+    i = j
+  }
+  return out, ms, rs
+}
+
+// next picks a mutation for an input of `size` bytes.
+func (m Mutator) next(size int64) Mutation {
+  mu := Mutation{ Kind: m.Kinds[m.Rand.Intn(len(m.Kinds))] }
+  if size == 0 && mu.Kind != Insert {
+    return mu
+  }
+
+  switch mu.Kind {
+  case ByteFlip, BitFlip:
+    mu.Offset = m.Rand.Int63n(size)
+    mu.Length = 1
+  case Insert:
+    mu.Offset = m.Rand.Int63n(size + 1)
+    mu.Length = 1 + m.Rand.Int63n(m.MaxLength)
+  case Delete, Move:
+    mu.Length = 1 + m.Rand.Int63n(m.MaxLength)
+    if mu.Length > size {
+      mu.Length = size
+    }
+    mu.Offset = m.Rand.Int63n(size - mu.Length + 1)
+  }
+
+  if mu.Kind == Move {
+    // A run moved back to where it was changes nothing, so it goes anywhere
+    // else. With nowhere else to go, there's no mutation at all.
+    if mu.Length == size {
+      mu.Length = 0
+      return mu
+    }
+    mu.To = m.Rand.Int63n(size - mu.Length)
+    if mu.To >= mu.Offset {
+      mu.To++
+    }
+  }
+  return mu
+}
+
+// apply makes a mutation to `b`, keeping `changed` in step with it.
+func apply(b []byte, changed []bool, mu Mutation, r *rand.Rand) ([]byte, []bool) {
+  at, end := mu.Offset, mu.Offset + mu.Length
+
+  switch mu.Kind {
+  case ByteFlip:
+    b[at] ^= byte(1 + r.Intn(255))
+    changed[at] = true
+  case BitFlip:
+    b[at] ^= 0x80 >> uint(r.Intn(8))
+    changed[at] = true
+  case Insert:
+    run := make([]byte, mu.Length)
+    r.Read(run)
+    b = append(b[:at], append(run, b[at:]...)...)
+    changed = append(changed[:at], append(trues(mu.Length), changed[at:]...)...)
+  case Delete:
+    b = append(b[:at], b[end:]...)
+    changed = append(changed[:at], changed[end:]...)
+    switch {
+    case at < int64(len(changed)):
+      changed[at] = true
+    case at > 0:
+      changed[at-1] = true
+    }
+  case Move:
+    run := append([]byte{}, b[at:end]...)
+    b = append(b[:at], b[end:]...)
+    changed = append(changed[:at], changed[end:]...)
+    b = append(b[:mu.To], append(run, b[mu.To:]...)...)
+    changed = append(changed[:mu.To], append(trues(mu.Length), changed[mu.To:]...)...)
+  }
+  return b, changed
+}
+
+func trues(n int64) []bool {
+  t := make([]bool, n)
+  for i := range t {
+    t[i] = true
+  }
+  return t
+}
@@ -0,0 +1,48 @@
+// Package logging carries the logger that the rest of mizudiff writes to in
+// a context, so that each call logs wherever its caller wants. Nothing is
+// logged for a context without one.
+package logging
+
+import(
+  "context"
+  "log/slog"
+)
+
+type key struct{}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+  return context.WithValue(ctx, key{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or Discard when there is
+// none.
+func FromContext(ctx context.Context) *slog.Logger {
+  if l, ok := ctx.Value(key{}).(*slog.Logger); ok && l != nil {
+    return l
+  }
+  return Discard()
+}
+
+// OrDiscard returns l, or Discard when l is nil.
+func OrDiscard(l *slog.Logger) *slog.Logger {
+  if l == nil {
+    return Discard()
+  }
+  return l
+}
+
+var discarding = slog.New(discard{})
+
+// Discard returns a logger that discards everything.
+func Discard() *slog.Logger {
+  return discarding
+}
+
+// discard is a handler that's never enabled.
+type discard struct{}
+
+func (discard) Enabled(context.Context, slog.Level) bool { return false }
+func (discard) Handle(context.Context, slog.Record) error { return nil }
+func (d discard) WithAttrs([]slog.Attr) slog.Handler { return d }
+func (d discard) WithGroup(string) slog.Handler { return d }
@@ -0,0 +1,40 @@
+package logging_test
+
+import(
+  "testing"
+  "github.com/pjrebsch/mizudiff/logging"
+  "bytes"
+  "context"
+  "log/slog"
+  "strings"
+)
+
+func TestFromContext(t *testing.T) {
+  ctx := context.Background()
+  if logging.FromContext(ctx).Enabled(ctx, slog.LevelError) {
+    t.Errorf("FromContext(): expected to discard everything by default")
+  }
+
+  var buf bytes.Buffer
+  ctx = logging.NewContext(ctx, slog.New(slog.NewTextHandler(&buf, nil)))
+  logging.FromContext(ctx).Info("hello", "n", 1)
+
+  if !strings.Contains(buf.String(), "msg=hello n=1") {
+    t.Errorf("NewContext(): expected the record to be written, got %q", buf.String())
+  }
+
+  ctx = logging.NewContext(ctx, nil)
+  if logging.FromContext(ctx).Enabled(ctx, slog.LevelError) {
+    t.Errorf("NewContext(nil): expected to discard everything again")
+  }
+}
+
+func TestOrDiscard(t *testing.T) {
+  if logging.OrDiscard(nil).Enabled(context.Background(), slog.LevelError) {
+    t.Errorf("OrDiscard(nil): expected to discard everything")
+  }
+  l := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+  if logging.OrDiscard(l) != l {
+    t.Errorf("OrDiscard(): expected the logger to be kept")
+  }
+}
@@ -1,50 +1,37 @@
 package main
 
 import (
+  "context"
+  "errors"
   "fmt"
-  "log"
+  "log/slog"
   "math"
-  // "encoding/hex"
-  "io/ioutil"
-  // "bytes"
+  "os"
+  "flag"
+  "strconv"
+  "strings"
   "github.com/pjrebsch/mizudiff/bitstr"
   "github.com/pjrebsch/mizudiff/bitpos"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/logging"
+  "github.com/pjrebsch/mizudiff/render"
+  "github.com/pjrebsch/mizudiff/source"
+  "github.com/pjrebsch/mizudiff/store"
 )
 
-func loadSources() ([]byte, []byte) {
-  a_raw, err := ioutil.ReadFile("testdata/git-2.9.5.tar.gz.txt")
+// loadSources opens the two experiment sources. The caller closes them once
+// it is done with their bytes.
+func loadSources(enc source.Encoding) (*source.Source, *source.Source, error) {
+  a, err := source.OpenContext(logContext(), "testdata/git-2.9.5.tar.gz.txt", enc)
   if err != nil {
-    log.Fatal(err)
+    return nil, nil, err
   }
-  b_raw, err := ioutil.ReadFile("testdata/git-2.9.5.tar.gz.txt-2")
+  b, err := source.OpenContext(logContext(), "testdata/git-2.9.5.tar.gz.txt-2", enc)
   if err != nil {
-    log.Fatal(err)
+    a.Close()
+    return nil, nil, err
   }
-
-  return a_raw, b_raw
-
-  // a_raw = bytes.Trim(a_raw, "\n")
-  // b_raw = bytes.Trim(b_raw, "\n")
-  //
-  // a := make([]byte, hex.DecodedLen(len(a_raw)))
-  // b := make([]byte, hex.DecodedLen(len(b_raw)))
-
-  // _, err = hex.Decode(a, a_raw)
-  // if err != nil {
-  //   log.Fatal(err)
-  // }
-  // _, err = hex.Decode(b, b_raw)
-  // if err != nil {
-  //   log.Fatal(err)
-  // }
-
-  // return a, b
-}
-
-// See https://dave.cheney.net/2014/09/28/using-build-to-switch-between-debug-and-release
-// for future implementation.
-func debug(str string, args ...interface{}) {
-	fmt.Printf(str, args...)
+  return a, b, nil
 }
 
 // SRC_BLOCK_SIZE must be even.
@@ -82,24 +69,31 @@ func translateSourceToSignaturePosition(pos int) int {
 func generateSourceSignature(src []byte) []byte {
   result := make([]byte, calculateSourceSignatureLength(len(src)))
 
+  // Each block is logged on its own line, indented to where it lands in
+  // the signature. Building the lines is skipped when nobody would see them.
+  var line strings.Builder
+  debug := logger.Enabled(context.Background(), slog.LevelDebug)
+
   prevtranspos := 0
 
   for i, b := range src {
     trans_pos := translateSourceToSignaturePosition(i)
 
-    if trans_pos < prevtranspos {
-      prefix := make([]byte, i / SRC_BLOCK_SIZE * (SIG_ADVANCE_RATE * 2))
-      for i := range prefix {
-        prefix[i] = ' '
-      }
-      fmt.Printf("\n%s", prefix)
+    if debug && trans_pos < prevtranspos {
+      logger.Debug("signature block", "layout", line.String())
+      line.Reset()
+      line.WriteString(strings.Repeat(" ", i / SRC_BLOCK_SIZE * (SIG_ADVANCE_RATE * 2)))
     }
     prevtranspos = trans_pos
-    fmt.Printf("%02x", b)
+    if debug {
+      fmt.Fprintf(&line, "%02x", b)
+    }
 
     result[trans_pos] ^= b
   }
-  fmt.Print("\n")
+  if debug {
+    logger.Debug("signature block", "layout", line.String())
+  }
 
   return result
 }
@@ -131,36 +125,196 @@ func diffCompare(a, b []byte) ([]byte) {
   return result
 }
 
-func prettyDiffComparison(diff []byte) {
-  for _, val := range diff {
-    if val == 0 {
-      fmt.Print("██")
-    } else {
-      fmt.Print("--")
-    }
-  }
-  fmt.Print("\n\n")
+// commands maps each subcommand name to the function that runs it with the
+// remaining arguments.
+var commands = map[string]func(args []string) error {
+  "digest": digestCommand,
+  "diff": diffCommand,
+  "serve": serveCommand,
+  "pull": pullCommand,
+  "store": storeCommand,
+  "nearest": nearestCommand,
+  "compare-many": compareManyCommand,
+  "cluster": clusterCommand,
+  "upgrade": upgradeCommand,
+  "eval": evalCommand,
+  "bench": benchCommand,
+  "experiment": experimentCommand,
+}
+
+// Exit codes, following cmp(1) and diff(1).
+const (
+  exitSame = 0       // the inputs are identical, or the command succeeded
+  exitDifferent = 1  // the inputs differ
+  exitError = 2      // something went wrong
+)
+
+// errDifferent is returned by commands that compare inputs when they
+// differ. It isn't reported as an error.
+var errDifferent = errors.New("inputs differ")
+
+// errInterrupted is returned by commands that were stopped by an interrupt
+// before they finished.
+var errInterrupted = errors.New("interrupted")
+
+func usage() {
+  fmt.Fprintln(os.Stderr, "usage: mizudiff <command> [arguments]")
+  fmt.Fprintln(os.Stderr, "")
+  fmt.Fprintln(os.Stderr, "commands:")
+  fmt.Fprintln(os.Stderr, "  digest       digest a file or directory")
+  fmt.Fprintln(os.Stderr, "  diff         report where two files or directories differ")
+  fmt.Fprintln(os.Stderr, "  serve        offer a file to pull over a socket")
+  fmt.Fprintln(os.Stderr, "  pull         update a local file from a serving peer")
+  fmt.Fprintln(os.Stderr, "  store        keep digests in a local store")
+  fmt.Fprintln(os.Stderr, "  nearest      find the stored digests that a file most resembles")
+  fmt.Fprintln(os.Stderr, "  compare-many find where some of many versions of a file disagree")
+  fmt.Fprintln(os.Stderr, "  cluster      group files by how much they resemble each other")
+  fmt.Fprintln(os.Stderr, "  upgrade      rewrite stored digests in a newer format")
+  fmt.Fprintln(os.Stderr, "  eval         measure how well diffs find random changes to a file")
+  fmt.Fprintln(os.Stderr, "  bench        measure digest speed and size across window layouts")
+  fmt.Fprintln(os.Stderr, "")
+  fmt.Fprintln(os.Stderr, "exit status:")
+  fmt.Fprintln(os.Stderr, "  0  inputs are identical, or the command succeeded")
+  fmt.Fprintln(os.Stderr, "  1  inputs differ")
+  fmt.Fprintln(os.Stderr, "  2  an error occurred")
 }
 
 func main() {
-  log.Println("Go-ing...")
-  a, b := loadSources()
+  if len(os.Args) < 2 {
+    parseFlags(flag.NewFlagSet("experiment", flag.ExitOnError), nil)
+    os.Exit(exitCode(experiment(source.Raw)))
+  }
+
+  cmd, ok := commands[os.Args[1]]
+  if !ok {
+    usage()
+    os.Exit(exitError)
+  }
+  os.Exit(exitCode(cmd(os.Args[2:])))
+}
+
+// verbosity counts how many times -v was given.
+type verbosity int
+
+func (v *verbosity) String() string { return strconv.Itoa(int(*v)) }
+func (v *verbosity) IsBoolFlag() bool { return true }
+
+func (v *verbosity) Set(s string) error {
+  on, err := strconv.ParseBool(s)
+  if err != nil {
+    return err
+  }
+  if on {
+    *v++
+  } else {
+    *v = 0
+  }
+  return nil
+}
+
+// logger is where commands log, as set up by parseFlags. Library calls only
+// log to it through logContext or their options.
+var logger = logging.Discard()
+
+// logContext returns a context that carries the logger.
+func logContext() context.Context {
+  return logging.NewContext(context.Background(), logger)
+}
+
+// openStore opens the store in `dir`, logging to the logger.
+func openStore(dir string) (*store.Store, error) {
+  s, err := store.Open(dir)
+  if err != nil {
+    return nil, err
+  }
+  s.Logger = logger
+  return s, nil
+}
+
+// parseFlags adds the logging flags that every command takes, parses the
+// arguments and sets up logging to standard error.
+func parseFlags(flags *flag.FlagSet, args []string) {
+  var v verbosity
+  flags.Var(&v, "v", "log progress; repeat for debug details")
+  format := flags.String("log-format", "text", "log format: text or json")
+  flags.Parse(args)
+
+  // Warnings are always shown.
+  level := slog.LevelWarn
+  switch {
+  case v == 1:
+    level = slog.LevelInfo
+  case v > 1:
+    level = slog.LevelDebug
+  }
+  opts := &slog.HandlerOptions{ Level: level }
+
+  var h slog.Handler
+  switch *format {
+  case "text":
+    h = slog.NewTextHandler(os.Stderr, opts)
+  case "json":
+    h = slog.NewJSONHandler(os.Stderr, opts)
+  default:
+    fmt.Fprintf(os.Stderr, "invalid value %q for flag -log-format\n", *format)
+    flags.Usage()
+    os.Exit(exitError)
+  }
+  logger = slog.New(h)
+}
+
+// exitCode reports an error from a command and picks the exit code for it.
+func exitCode(err error) int {
+  switch {
+  case err == nil:
+    return exitSame
+  case errors.Is(err, errDifferent):
+    return exitDifferent
+  }
+  fmt.Fprintf(os.Stderr, "mizudiff: %v\n", err)
+  return exitError
+}
+
+func experimentCommand(args []string) error {
+  flags := flag.NewFlagSet("experiment", flag.ExitOnError)
+  enc := flags.String("encoding", "raw", "how the sources are stored: raw, hex, base64 or xxd")
+  parseFlags(flags, args)
+
+  e, err := source.ParseEncoding(*enc)
+  if err != nil {
+    return err
+  }
+  return experiment(e)
+}
+
+// experiment digests and diffs the sources from loadSources.
+func experiment(enc source.Encoding) error {
+  log := logger
+
+  log.Info("loading sources", "encoding", enc.String())
+  srcA, srcB, err := loadSources(enc)
+  if err != nil {
+    return err
+  }
+  defer srcA.Close()
+  defer srcB.Close()
+  a, b := srcA.Bytes(), srcB.Bytes()
   // a := []byte("abcd")
   // b := []byte("abcdefghijklmnopqrstuvwxyz...............abcdefghijklmnopqrstuvwxyz")
   // a := []byte("abcd")
   // b := []byte("0abcd")
 
-  sa, sb := bitstr.New(a), bitstr.New(b)
+  sa, sb := srcA.BitString(), srcB.BitString()
 
-  log.Println("Digesting...")
+  log.Info("digesting")
 
   da, err := sa.XORCompress(1,8)
   if err != nil {
-    log.Fatalln(err)
+    return err
   }
   db, err := sb.XORCompress(1,8)
   if err != nil {
-    log.Fatalln(err)
+    return err
   }
 
   // db, _ = db.Shift(bitpos.New(0,-1))
@@ -171,8 +325,10 @@ func main() {
   la, _ := da.Length().CeilByteOffset()
   lb, _ := db.Length().CeilByteOffset()
 
-  debug("len(A): %#v | len(digest_A): %#v | Ratio: %f%%\n", len(a), la, float64(la)/float64(len(a))*100)
-  debug("len(B): %#v | len(digest_B): %#v | Ratio: %f%%\n", len(b), lb, float64(lb)/float64(len(b))*100)
+  log.Info("digested A", "source_bytes", len(a), "digest_bytes", la,
+    "ratio_percent", float64(la)/float64(len(a))*100)
+  log.Info("digested B", "source_bytes", len(b), "digest_bytes", lb,
+    "ratio_percent", float64(lb)/float64(len(b))*100)
 
   // for i := 0; i < 5; i += 1 {
   //   new_block := make([]byte, SRC_BLOCK_SIZE)
@@ -191,15 +347,15 @@ func main() {
     // debug("%08b\n", sigB)
   //
     // res := diffCompare(sigA, sigB)
-    // prettyDiffComparison(res)
   // }
 
-  log.Println("Diffing...")
+  log.Info("diffing")
 
   diff, err := bitstr.Diff(da, db, bitpos.New(1,0))
   if err != nil {
-    log.Fatalln(err)
+    return err
   }
 
-  debug("diff: %08b\n", diff.Bytes())
+  opts := render.HeatmapOptions{ Width: terminalWidth(), Color: true }
+  return render.Heatmap(os.Stdout, diff, digest.Config_0{}, opts)
 }
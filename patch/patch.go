@@ -0,0 +1,208 @@
+package patch
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/logging"
+  "bytes"
+  "context"
+  "crypto/sha256"
+  "encoding/binary"
+  "errors"
+)
+
+const CurrentVersion = 0x0
+
+// Hunk holds the target bytes that replace everything starting at Offset.
+type Hunk struct {
+  Offset uint64
+  Data []byte
+}
+
+// Patch describes how to turn a source into a target. Only the regions that
+// the digests flagged as different are carried; everything else is taken
+// from the source as is.
+type Patch struct {
+  Version uint32
+  Length uint64  // byte length of the target
+  Sum [sha256.Size]byte  // SHA-256 of the target
+  Hunks []Hunk
+}
+
+// New creates a patch that turns `a` into `b`, where `target` is the digest
+// of `b`. Since XOR collisions can hide a change from the digests, the patch
+// is checked against `a` and falls back to carrying all of `b` when needed.
+func New(a []byte, target digest.Digest, b []byte) (Patch, error) {
+  return NewContext(context.Background(), a, target, b)
+}
+
+// NewContext is New, logging to the context's logger.
+func NewContext(ctx context.Context, a []byte, target digest.Digest, b []byte) (Patch, error) {
+  source, err := digest.NewContext(ctx, bitstr.New(a), nil)
+  if err != nil {
+    return Patch{}, err
+  }
+
+  p, err := Make(source, target, b)
+  if err != nil {
+    return Patch{}, err
+  }
+
+  if _, err := Apply(a, p); err != nil {
+    if err != ErrChecksum {
+      return Patch{}, err
+    }
+    logging.FromContext(ctx).Warn("digests missed a change, patching with the full target",
+      "target_size", len(b))
+    p.Hunks = []Hunk{ { 0, b } }
+  }
+  return p, nil
+}
+
+// Make creates a patch for a target `b` from the digest of a source that
+// isn't available locally. The result can't be checked until it is applied.
+func Make(source, target digest.Digest, b []byte) (Patch, error) {
+  rs, err := digest.Regions(source, target)
+  if err != nil {
+    return Patch{}, err
+  }
+
+  p := Patch{
+    Version: CurrentVersion,
+    Length: uint64(len(b)),
+    Sum: sha256.Sum256(b),
+    Hunks: []Hunk{},
+  }
+
+  for _, r := range rs {
+    if r.Offset >= int64(len(b)) {
+      break
+    }
+    end := r.End()
+    if end > int64(len(b)) {
+      end = int64(len(b))
+    }
+    p.Hunks = append(p.Hunks, Hunk{ uint64(r.Offset), b[r.Offset:end] })
+  }
+  return p, nil
+}
+
+// ErrChecksum is returned by Apply when the reconstructed target doesn't
+// match the hash recorded in the patch.
+var ErrChecksum = errors.New("patched data does not match the patch checksum")
+
+// Apply reconstructs the target of a patch from its source.
+func Apply(a []byte, p Patch) ([]byte, error) {
+  if err := p.validate(); err != nil {
+    return nil, err
+  }
+
+  // Every target byte comes from either the source or a hunk, which also
+  // keeps a bogus length from causing a huge allocation.
+  covered := uint64(len(a))
+  for _, h := range p.Hunks {
+    covered += uint64(len(h.Data))
+  }
+  if p.Length > covered {
+    return nil, errors.New("patch target is longer than its source and hunks combined")
+  }
+
+  out := make([]byte, p.Length)
+  copy(out, a)
+
+  for _, h := range p.Hunks {
+    copy(out[h.Offset:], h.Data)
+  }
+
+  if sha256.Sum256(out) != p.Sum {
+    return nil, ErrChecksum
+  }
+  return out, nil
+}
+
+// Bytes serializes the patch.
+func (p Patch) Bytes() []byte {
+  var buf bytes.Buffer
+
+  head := make([]byte, 4 + 8)
+  binary.BigEndian.PutUint32(head[0:4], p.Version)
+  binary.BigEndian.PutUint64(head[4:12], p.Length)
+  buf.Write(head)
+  buf.Write(p.Sum[:])
+
+  n := make([]byte, 4)
+  binary.BigEndian.PutUint32(n, uint32(len(p.Hunks)))
+  buf.Write(n)
+
+  for _, h := range p.Hunks {
+    hunk := make([]byte, 8 + 8)
+    binary.BigEndian.PutUint64(hunk[0:8], h.Offset)
+    binary.BigEndian.PutUint64(hunk[8:16], uint64(len(h.Data)))
+    buf.Write(hunk)
+    buf.Write(h.Data)
+  }
+
+  return buf.Bytes()
+}
+
+// Load parses a serialized patch.
+func Load(raw []byte) (Patch, error) {
+  headSize := 4 + 8 + sha256.Size + 4
+  if len(raw) < headSize {
+    return Patch{}, errors.New("patch data is too short to contain a header")
+  }
+
+  p := Patch{}
+  p.Version = binary.BigEndian.Uint32(raw[0:4])
+  if p.Version != CurrentVersion {
+    return Patch{}, errors.New("patch version is not recognized")
+  }
+  p.Length = binary.BigEndian.Uint64(raw[4:12])
+  copy(p.Sum[:], raw[12:12 + sha256.Size])
+
+  count := binary.BigEndian.Uint32(raw[headSize-4:headSize])
+  raw = raw[headSize:]
+
+  p.Hunks = []Hunk{}
+
+  for i := uint32(0); i < count; i++ {
+    if len(raw) < 16 {
+      return Patch{}, errors.New("patch data is too short to contain a hunk")
+    }
+    offset := binary.BigEndian.Uint64(raw[0:8])
+    length := binary.BigEndian.Uint64(raw[8:16])
+    raw = raw[16:]
+
+    if length > uint64(len(raw)) {
+      return Patch{}, errors.New("patch hunk length is greater than the remaining data")
+    }
+    p.Hunks = append(p.Hunks, Hunk{ offset, raw[:length] })
+    raw = raw[length:]
+  }
+
+  if len(raw) != 0 {
+    return Patch{}, errors.New("patch data continues past its last hunk")
+  }
+
+  if err := p.validate(); err != nil {
+    return Patch{}, err
+  }
+  return p, nil
+}
+
+// validate makes sure that the hunks are in order, don't overlap and stay
+// within the target.
+func (p Patch) validate() error {
+  next := uint64(0)
+  for _, h := range p.Hunks {
+    if h.Offset < next {
+      return errors.New("patch hunks overlap or are out of order")
+    }
+    end := h.Offset + uint64(len(h.Data))
+    if end < h.Offset || end > p.Length {
+      return errors.New("patch hunk extends past the end of the target")
+    }
+    next = end
+  }
+  return nil
+}
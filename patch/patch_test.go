@@ -0,0 +1,127 @@
+package patch_test
+
+import(
+  "testing"
+  "github.com/pjrebsch/mizudiff/patch"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "math"
+  "math/rand"
+  "bytes"
+)
+
+func TestApply(t *testing.T) {
+  src := deterministicBytes(4096, 5530021)
+
+  var tbl = []struct {
+    name string
+    mutate func([]byte) []byte
+  }{
+    {"identical", func(b []byte) []byte { return b }},
+    {"single byte", func(b []byte) []byte { b[100] ^= 0xff; return b }},
+    {"scattered bytes", func(b []byte) []byte {
+      b[0] ^= 0x01; b[1000] ^= 0x10; b[4095] ^= 0x80
+      return b
+    }},
+    {"appended", func(b []byte) []byte { return append(b, 0x01, 0x02, 0x03) }},
+    {"truncated", func(b []byte) []byte { return b[:3000] }},
+    {"emptied", func(b []byte) []byte { return []byte{} }},
+  }
+  for _, e := range tbl {
+    t.Run(e.name, func(t *testing.T) {
+      a := append([]byte{}, src...)
+      b := e.mutate(append([]byte{}, src...))
+
+      target, err := digest.New(bitstr.New(b))
+      if err != nil {
+        t.Fatalf("digest.New(): did not expect an error, but got one: %v", err)
+      }
+
+      p, err := patch.New(a, target, b)
+      if err != nil {
+        t.Fatalf("New(): did not expect an error, but got one: %v", err)
+      }
+
+      loaded, err := patch.Load(p.Bytes())
+      if err != nil {
+        t.Fatalf("Load(): did not expect an error, but got one: %v", err)
+      }
+
+      actual, err := patch.Apply(a, loaded)
+      if err != nil {
+        t.Fatalf("Apply(): did not expect an error, but got one: %v", err)
+      }
+      if !bytes.Equal(actual, b) {
+        t.Errorf("Apply(): did not reconstruct the target")
+      }
+
+      size := 0
+      for _, h := range p.Hunks {
+        size += len(h.Data)
+      }
+      if len(b) > 0 && size == len(b) && len(a) >= len(b) {
+        t.Errorf("New(): expected a partial patch, but it carries the whole target")
+      }
+    })
+  }
+
+  t.Run("detects a mismatched source", func(t *testing.T) {
+    a := deterministicBytes(256, 88122)
+    b := append([]byte{}, a...)
+    b[10] ^= 0xff
+
+    target, _ := digest.New(bitstr.New(b))
+    p, err := patch.New(a, target, b)
+    if err != nil {
+      t.Fatalf("New(): did not expect an error, but got one: %v", err)
+    }
+
+    a[200] ^= 0xff
+
+    _, err = patch.Apply(a, p)
+    if err != patch.ErrChecksum {
+      t.Errorf("Apply(): expected %v, got %v", patch.ErrChecksum, err)
+    }
+  })
+}
+
+func TestLoad(t *testing.T) {
+  valid := patch.Patch{ Length: 4, Hunks: []patch.Hunk{ { 1, []byte{ 0xaa } } } }.Bytes()
+
+  var tbl = []struct {
+    name string
+    raw []byte
+  }{
+    {"too short for a header", valid[:10]},
+    {"unrecognized version", append([]byte{ 0x01 }, valid[1:]...)},
+    {"too short for a hunk", valid[:len(valid)-2]},
+    {"trailing data", append(append([]byte{}, valid...), 0x00)},
+    {"hunk past the target", patch.Patch{ Length: 1, Hunks: []patch.Hunk{ { 1, []byte{ 0xaa } } } }.Bytes()},
+    {"overlapping hunks", patch.Patch{ Length: 4, Hunks: []patch.Hunk{
+      { 0, []byte{ 0xaa, 0xbb } }, { 1, []byte{ 0xcc } },
+    } }.Bytes()},
+  }
+  for _, e := range tbl {
+    _, err := patch.Load(e.raw)
+    if err == nil {
+      t.Errorf("Load(%s): expected an error, but didn't get one", e.name)
+    }
+  }
+
+  if _, err := patch.Load(valid); err != nil {
+    t.Errorf("Load(0x%02x): did not expect an error, but got one: %v", valid, err)
+  }
+}
+
+func deterministicBytes(length int, seed int64) []byte {
+  src := rand.NewSource(seed)
+  r := rand.New(src)
+
+  str := make([]byte, length, length)
+
+  for i := 0; i < length; i++ {
+    str[i] = byte(r.Intn(math.MaxUint8))
+  }
+
+  return str
+}
@@ -0,0 +1,111 @@
+package render
+
+import(
+  "github.com/pjrebsch/mizudiff/bitpos"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "errors"
+  "fmt"
+  "io"
+  "strconv"
+)
+
+// HeatmapOptions controls the layout of a heatmap.
+type HeatmapOptions struct {
+  Width int    // columns available, including the axis labels
+  MaxRows int  // rows to use at most; 0 means 8
+  Color bool   // shade cells with ANSI colors as well
+}
+
+// shades go from no differing windows to all of them.
+var shades = []string{ "·", "░", "▒", "▓", "█" }
+
+// colors are ANSI 256-color foregrounds matching shades.
+var colors = []int{ 46, 190, 220, 208, 196 }
+
+// Heatmap draws a diff produced by digest.Diff as rows of cells, each
+// covering the same number of windows and shaded by the fraction of them
+// that differ. Rows are labeled with the source byte offset they start at.
+func Heatmap(w io.Writer, diff bitstr.BitString, c digest.Config, opts HeatmapOptions) error {
+  if opts.MaxRows == 0 {
+    opts.MaxRows = 8
+  }
+
+  n := diff.Length().Int64()
+  end := SourceOffset(c, n)
+  label := len(strconv.FormatInt(end, 10))
+
+  cols := opts.Width - label - 3
+  if cols < 1 || opts.MaxRows < 1 {
+    return errors.New("heatmap doesn't fit in the given width")
+  }
+
+  perCell := int64(1)
+  if cells := int64(cols * opts.MaxRows); n > cells {
+    perCell = (n + cells - 1) / cells
+  }
+
+  for j := int64(0); j < n; {
+    fmt.Fprintf(w, "%*d │", label, SourceOffset(c, j))
+
+    for col := 0; col < cols && j < n; col++ {
+      count := perCell
+      if j + count > n {
+        count = n - j
+      }
+
+      set := int64(0)
+      for k := j; k < j + count; k++ {
+        bit, err := diff.Bit(bitpos.New(0, k))
+        if err != nil {
+          return err
+        }
+        if bit {
+          set++
+        }
+      }
+      writeCell(w, shade(set, count), opts.Color)
+
+      j += count
+    }
+    fmt.Fprintln(w)
+  }
+
+  fmt.Fprintf(w, "%*d ┘\n", label, end)
+  fmt.Fprintf(w, "%s 0%%  ", cell(0, opts.Color))
+  for i := 1; i < len(shades); i++ {
+    fmt.Fprintf(w, "%s ≤%d%%  ", cell(i, opts.Color), i * 100 / (len(shades) - 1))
+  }
+  fmt.Fprintf(w, "(each cell is %d window(s), about %d bytes)\n",
+    perCell, SourceOffset(c, perCell))
+  return nil
+}
+
+// SourceOffset returns roughly where in the source the diff window `j`
+// begins. Diff windows are as wide as the digest's windows.
+func SourceOffset(c digest.Config, j int64) int64 {
+  win := int64(c.WindowSize())
+  bits := j * win * win / int64(c.AdvanceRate())
+  return bits / bitpos.C
+}
+
+// shade picks the shade for `set` out of `count` differing windows. Only
+// a cell without any differing window gets the lightest shade.
+func shade(set, count int64) int {
+  if set == 0 {
+    return 0
+  }
+  top := int64(len(shades) - 1)
+  return int((set * top + count - 1) / count)
+}
+
+func cell(i int, color bool) string {
+  if !color {
+    return shades[i]
+  }
+  return "\x1b[38;5;" + strconv.Itoa(colors[i]) + "m" + shades[i] + "\x1b[0m"
+}
+
+func writeCell(w io.Writer, i int, color bool) {
+  io.WriteString(w, cell(i, color))
+}
@@ -0,0 +1,84 @@
+package render_test
+
+import(
+  "testing"
+  "github.com/pjrebsch/mizudiff/render"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/bitpos"
+  "github.com/pjrebsch/mizudiff/digest"
+  "bytes"
+  "strings"
+)
+
+func diffOf(bits string) bitstr.BitString {
+  b := make([]byte, (len(bits) + 7) / 8)
+  for i, c := range bits {
+    if c == '1' {
+      b[i/8] |= 0x80 >> uint(i % 8)
+    }
+  }
+  s := bitstr.New(b)
+  s.SetLength(bitpos.New(0, int64(len(bits))))
+  return s
+}
+
+func TestHeatmap(t *testing.T) {
+  var tbl = []struct {
+    bits string
+    opts render.HeatmapOptions
+    out string
+  }{
+    {
+      "0110",
+      render.HeatmapOptions{ Width: 10 },
+      " 0 │·██·\n" +
+      "32 ┘\n",
+    }, {
+      // Two rows of three cells, each cell taking in two windows.
+      "110000100001",
+      render.HeatmapOptions{ Width: 8, MaxRows: 2 },
+      " 0 │█··\n" +
+      "48 │▒·▒\n" +
+      "96 ┘\n",
+    },
+  }
+  for _, e := range tbl {
+    var buf bytes.Buffer
+    err := render.Heatmap(&buf, diffOf(e.bits), digest.Config_0{}, e.opts)
+    if err != nil {
+      t.Fatalf("Heatmap(%s): did not expect an error, but got one: %v", e.bits, err)
+    }
+
+    // Leave the legend out of the comparison.
+    lines := strings.SplitAfter(buf.String(), "\n")
+    actual := strings.Join(lines[:len(lines)-2], "")
+
+    if actual != e.out {
+      t.Errorf("Heatmap(%s): expected\n%s\ngot\n%s", e.bits, e.out, actual)
+    }
+  }
+
+  t.Run("colors cells when asked", func(t *testing.T) {
+    var buf bytes.Buffer
+    opts := render.HeatmapOptions{ Width: 10, Color: true }
+    render.Heatmap(&buf, diffOf("01"), digest.Config_0{}, opts)
+
+    if !strings.Contains(buf.String(), "\x1b[38;5;196m█\x1b[0m") {
+      t.Errorf("Heatmap(): expected a red cell, got %q", buf.String())
+    }
+  })
+
+  t.Run("width must leave room for cells", func(t *testing.T) {
+    var buf bytes.Buffer
+    opts := render.HeatmapOptions{ Width: 3 }
+    if err := render.Heatmap(&buf, diffOf("01"), digest.Config_0{}, opts); err == nil {
+      t.Errorf("Heatmap(): expected an error, but didn't get one")
+    }
+  })
+}
+
+func TestSourceOffset(t *testing.T) {
+  if actual := render.SourceOffset(digest.Config_0{}, 3); actual != 24 {
+    t.Errorf("SourceOffset(3): expected 24, got %d", actual)
+  }
+}
@@ -0,0 +1,185 @@
+package render
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "bytes"
+  "fmt"
+  "html"
+  "html/template"
+  "io"
+  "math"
+)
+
+// Strip is the diff of a single file as drawn in a report. Spans, from
+// digest.Spans, are the runs that get marked, so that the bytes they are
+// labelled with match the regions of the other reports.
+type Strip struct {
+  Name string
+  SizeA, SizeB int64
+  Config digest.Config
+  Diff bitstr.BitString
+  Spans []digest.Span
+}
+
+// Windows returns how many windows were compared and how many of them
+// differ.
+func (s Strip) Windows() (int64, int64) {
+  return s.Diff.Length().Int64(), s.Diff.Count()
+}
+
+// Similarity returns the fraction of windows that are equal, where a file
+// without any windows counts as identical.
+func (s Strip) Similarity() float64 {
+  n, set := s.Windows()
+  if n == 0 {
+    return 1
+  }
+  return 1 - float64(set) / float64(n)
+}
+
+const (
+  stripHeight = 24
+  labelHeight = 18
+)
+
+// SVG draws every strip as a bar across the full width of the image, with
+// the differing windows marked in red. Hovering over a mark shows the
+// source bytes it stands for.
+func SVG(w io.Writer, strips []Strip) error {
+  var body bytes.Buffer
+  if err := writeStrips(&body, strips, true); err != nil {
+    return err
+  }
+
+  height := len(strips) * (stripHeight + labelHeight)
+  fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="1000" height="%d" `+
+    `viewBox="0 0 1000 %d" preserveAspectRatio="none" font-family="monospace" font-size="12">`+"\n",
+    height, height)
+  body.WriteTo(w)
+  _, err := io.WriteString(w, "</svg>\n")
+  return err
+}
+
+// writeStrips writes the SVG elements of every strip, scaled to a width of
+// 1000 units. Labels are left out when the strips are going to be
+// stretched, since the text would be too.
+func writeStrips(w *bytes.Buffer, strips []Strip, labels bool) error {
+  label := 0
+  if labels {
+    label = labelHeight
+  }
+
+  for i, s := range strips {
+    n, set := s.Windows()
+    sim := s.Similarity()
+
+    y := i * (stripHeight + label)
+    summary := fmt.Sprintf("%s: %d -> %d bytes, %d of %d windows differ, %.2f%% similar",
+      s.Name, s.SizeA, s.SizeB, set, n, sim * 100)
+
+    if labels {
+      fmt.Fprintf(w, `<text x="0" y="%d">%s</text>`+"\n", y + label - 5,
+        html.EscapeString(summary))
+    }
+    fmt.Fprintf(w, `<rect x="0" y="%d" width="1000" height="%d" fill="#3c9a5f"><title>%s</title></rect>`+"\n",
+      y + label, stripHeight, html.EscapeString(summary))
+
+    if n == 0 {
+      continue
+    }
+    // Spans are in digest bits, and every diff window is as wide as a
+    // digest window.
+    win := int64(s.Config.WindowSize())
+    scale := 1000 / float64(n * win)
+
+    for _, sp := range s.Spans {
+      tip := fmt.Sprintf("%s: windows %d-%d differ", s.Name, sp.From / win, (sp.To - 1) / win)
+      if sp.Source.Length > 0 {
+        tip = fmt.Sprintf("%s: bytes %d-%d differ (windows %d-%d)", s.Name,
+          sp.Source.Offset, sp.Source.End() - 1, sp.From / win, (sp.To - 1) / win)
+      }
+
+      // A span past the end of the shorter digest can run off the strip.
+      x := math.Min(float64(sp.From) * scale, 1000)
+      width := math.Min(float64(sp.To) * scale, 1000) - x
+      // Keep every mark at least a sliver wide so it doesn't vanish.
+      if width < 0.5 {
+        width = 0.5
+      }
+      fmt.Fprintf(w, `<rect x="%.3f" y="%d" width="%.3f" height="%d" fill="#d7263d"><title>%s</title></rect>`+"\n",
+        x, y + label, width, stripHeight, html.EscapeString(tip))
+    }
+  }
+  return nil
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: right; }
+td:first-child, th:first-child { text-align: left; }
+#strips { overflow-x: auto; border: 1px solid #ccc; }
+#strips svg { width: 100%; height: {{.Height}}px; display: block; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>File</th><th>Size A</th><th>Size B</th><th>Windows</th><th>Differing</th><th>Similarity</th></tr>
+{{range .Rows}}<tr><td>{{.Name}}</td><td>{{.SizeA}}</td><td>{{.SizeB}}</td><td>{{.Windows}}</td><td>{{.Differing}}</td><td>{{printf "%.2f" .Similarity}}%</td></tr>
+{{end}}</table>
+<p><label>Zoom <input id="zoom" type="range" min="1" max="64" value="1"></label> Hover over a red mark to see the bytes it covers.</p>
+<div id="strips">
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 1000 {{.Height}}" preserveAspectRatio="none" font-family="monospace" font-size="12">
+{{.Strips}}</svg>
+</div>
+<script>
+document.getElementById("zoom").addEventListener("input", function (e) {
+  document.querySelector("#strips svg").style.width = (e.target.value * 100) + "%";
+});
+</script>
+</body>
+</html>
+`))
+
+type reportRow struct {
+  Name string
+  SizeA, SizeB int64
+  Windows, Differing int64
+  Similarity float64
+}
+
+// HTML writes a standalone page holding a summary table and the strips as
+// SVG, in the same order, which can be zoomed into. Nothing outside of the
+// page is loaded.
+func HTML(w io.Writer, title string, strips []Strip) error {
+  var body bytes.Buffer
+  if err := writeStrips(&body, strips, false); err != nil {
+    return err
+  }
+
+  rows := []reportRow{}
+  for _, s := range strips {
+    n, set := s.Windows()
+    rows = append(rows, reportRow{ s.Name, s.SizeA, s.SizeB, n, set, s.Similarity() * 100 })
+  }
+
+  return reportTemplate.Execute(w, struct {
+    Title string
+    Height int
+    Rows []reportRow
+    Strips template.HTML
+  }{
+    title,
+    len(strips) * stripHeight,
+    rows,
+    // The strips were escaped as they were written.
+    template.HTML(body.String()),
+  })
+}
@@ -0,0 +1,105 @@
+package render_test
+
+import(
+  "testing"
+  "github.com/pjrebsch/mizudiff/render"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "bytes"
+  "encoding/xml"
+  "io"
+  "strings"
+)
+
+// stripOf draws the diff of two sources the way the diff command does.
+func stripOf(name string, a, b []byte) render.Strip {
+  da, _ := digest.New(bitstr.New(a))
+  db, _ := digest.New(bitstr.New(b))
+  diff, _ := digest.Diff(da, db)
+  ss, _ := digest.Spans(da, db)
+  return render.Strip{ name, int64(len(a)), int64(len(b)), da.Config, diff, ss }
+}
+
+func strips() []render.Strip {
+  a := bytes.Repeat([]byte("0123456789"), 10)
+  b := append([]byte{}, a...)
+  b[20], b[21], b[95] = 'x', 'y', 'z'
+  return []render.Strip{
+    stripOf("a<b>.bin", a, b),
+    stripOf("same.bin", a[:16], a[:16]),
+  }
+}
+
+func TestSimilarity(t *testing.T) {
+  var tbl = []struct {
+    bits string
+    r float64
+  }{
+    {"", 1},
+    {"0000", 1},
+    {"0101", 0.5},
+    {"1111", 0},
+  }
+  for _, e := range tbl {
+    s := render.Strip{ Config: digest.Config_0{}, Diff: diffOf(e.bits) }
+    if actual := s.Similarity(); actual != e.r {
+      t.Errorf("Similarity(%s): expected %v, got %v", e.bits, e.r, actual)
+    }
+  }
+}
+
+func TestSVG(t *testing.T) {
+  var buf bytes.Buffer
+  if err := render.SVG(&buf, strips()); err != nil {
+    t.Fatalf("SVG(): did not expect an error, but got one: %v", err)
+  }
+  out := buf.String()
+
+  // The output must be well formed XML.
+  d := xml.NewDecoder(strings.NewReader(out))
+  for {
+    _, err := d.Token()
+    if err != nil {
+      if err != io.EOF {
+        t.Fatalf("SVG(): produced malformed XML: %v", err)
+      }
+      break
+    }
+  }
+
+  for _, s := range []string{
+    "a&lt;b&gt;.bin: 100 -&gt; 100 bytes, 3 of 14 windows differ, 78.57% similar",
+    "a&lt;b&gt;.bin: bytes 9-31 differ (windows 2-3)",
+    "a&lt;b&gt;.bin: bytes 89-99 differ (windows 12-12)",
+    "same.bin: 16 -&gt; 16 bytes, 0 of 3 windows differ, 100.00% similar",
+  } {
+    if !strings.Contains(out, s) {
+      t.Errorf("SVG(): expected the output to contain %q", s)
+    }
+  }
+}
+
+func TestHTML(t *testing.T) {
+  var buf bytes.Buffer
+  if err := render.HTML(&buf, "a & b", strips()); err != nil {
+    t.Fatalf("HTML(): did not expect an error, but got one: %v", err)
+  }
+  out := buf.String()
+
+  for _, s := range []string{
+    "<title>a &amp; b</title>",
+    "<td>a&lt;b&gt;.bin</td>",
+    "<td>78.57%</td>",
+    "bytes 9-31 differ",
+  } {
+    if !strings.Contains(out, s) {
+      t.Errorf("HTML(): expected the output to contain %q", s)
+    }
+  }
+
+  for _, s := range []string{ "src=", "href=", "@import", "url(" } {
+    if strings.Contains(out, s) {
+      t.Errorf("HTML(): expected no external references, but found %q", s)
+    }
+  }
+}
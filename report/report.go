@@ -0,0 +1,161 @@
+package report
+
+import(
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/tree"
+  "encoding/json"
+  "io"
+)
+
+// SchemaVersion is bumped whenever a field is removed or changes meaning.
+// Fields may be added without bumping it.
+const SchemaVersion = 1
+
+// Report is the machine readable result of a diff.
+type Report struct {
+  SchemaVersion int `json:"schema_version"`
+  Inputs []Input `json:"inputs"`
+  Summary Summary `json:"summary"`
+  Files []File `json:"files"`
+}
+
+// Input describes one side of the comparison as it was given.
+type Input struct {
+  Path string `json:"path"`
+  Kind string `json:"kind"`  // "file", "directory", "tree" or "archive"
+  Encoding string `json:"encoding"`
+  Gunzip bool `json:"gunzip"`
+}
+
+// Summary totals up the files.
+type Summary struct {
+  Added int `json:"added"`
+  Removed int `json:"removed"`
+  Changed int `json:"changed"`
+  Windows int64 `json:"windows"`
+  DifferingWindows int64 `json:"differing_windows"`
+}
+
+// File is a single path that differs. A and B are left out for the side
+// that doesn't have the file, and Windows is left out when the digests
+// can't be compared window by window.
+type File struct {
+  Path string `json:"path"`
+  Status string `json:"status"`  // "added", "removed" or "changed"
+  A *Side `json:"a,omitempty"`
+  B *Side `json:"b,omitempty"`
+  Windows *Windows `json:"windows,omitempty"`
+  Ranges []Range `json:"ranges"`
+}
+
+// Side describes a file on one side of the comparison.
+type Side struct {
+  Mode string `json:"mode"`
+  Size int64 `json:"size"`
+  Digest Digest `json:"digest"`
+}
+
+// Digest describes the digest of a file.
+type Digest struct {
+  Version uint32 `json:"version"`
+  AdvanceRate uint16 `json:"advance_rate_bits"`
+  WindowSize uint16 `json:"window_size_bits"`
+  Length int64 `json:"length_bits"`
+  // Ratio is the size of the digest over the size of the file, or 0 for an
+  // empty file.
+  Ratio float64 `json:"compression_ratio"`
+}
+
+// Windows counts the compared windows of a file.
+type Windows struct {
+  Total int64 `json:"total"`
+  Differing int64 `json:"differing"`
+}
+
+// Range is a run of differing windows. Both ranges are half open.
+type Range struct {
+  DigestBits Interval `json:"digest_bits"`
+  SourceBytes Interval `json:"source_bytes"`
+}
+
+type Interval struct {
+  Start int64 `json:"start"`
+  End int64 `json:"end"`
+}
+
+// New builds a report from the changes between two inputs.
+func New(inputs []Input, cs []tree.Change) Report {
+  r := Report{
+    SchemaVersion: SchemaVersion,
+    Inputs: inputs,
+    Files: []File{},
+  }
+
+  for _, c := range cs {
+    f := File{ Path: c.Path, Status: c.Kind.String(), Ranges: []Range{} }
+
+    switch c.Kind {
+    case tree.Added:
+      r.Summary.Added++
+      f.B = side(c.ModeB.String(), c.SizeB, c.DigestB)
+    case tree.Removed:
+      r.Summary.Removed++
+      f.A = side(c.ModeA.String(), c.SizeA, c.DigestA)
+    case tree.Changed:
+      r.Summary.Changed++
+      f.A = side(c.ModeA.String(), c.SizeA, c.DigestA)
+      f.B = side(c.ModeB.String(), c.SizeB, c.DigestB)
+    }
+
+    if c.Config != nil {
+      f.Windows = &Windows{ c.Diff.Length().Int64(), c.Diff.Count() }
+      r.Summary.Windows += f.Windows.Total
+      r.Summary.DifferingWindows += f.Windows.Differing
+    }
+
+    for _, s := range c.Spans {
+      f.Ranges = append(f.Ranges, Range{
+        Interval{ s.From, s.To },
+        Interval{ s.Source.Offset, s.Source.End() },
+      })
+    }
+    if c.Spans == nil {
+      // Without spans only the source regions are known.
+      for _, rg := range c.Regions {
+        f.Ranges = append(f.Ranges, Range{
+          SourceBytes: Interval{ rg.Offset, rg.End() },
+        })
+      }
+    }
+
+    r.Files = append(r.Files, f)
+  }
+
+  return r
+}
+
+func side(mode string, size int64, d digest.Digest) *Side {
+  s := &Side{ Mode: mode, Size: size }
+  if d.Config == nil {
+    return s
+  }
+
+  l := d.Data.Length().Int64()
+  s.Digest = Digest{
+    Version: d.Version,
+    AdvanceRate: d.Config.AdvanceRate(),
+    WindowSize: d.Config.WindowSize(),
+    Length: l,
+  }
+  if size > 0 {
+    s.Digest.Ratio = float64((l + 7) / 8) / float64(size)
+  }
+  return s
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(r)
+}
@@ -0,0 +1,92 @@
+package report_test
+
+import(
+  "testing"
+  "github.com/pjrebsch/mizudiff/report"
+  "github.com/pjrebsch/mizudiff/tree"
+  "bytes"
+  "encoding/json"
+  "reflect"
+)
+
+func TestNew(t *testing.T) {
+  a, b := tree.Tree{}, tree.Tree{}
+
+  data := bytes.Repeat([]byte{ 0x3c }, 40)
+  changed := append([]byte{}, data...)
+  changed[30] = 0x00
+
+  a.Add("changed", 0644, data)
+  b.Add("changed", 0644, changed)
+  a.Add("removed", 0644, []byte{})
+  b.Add("added", 0644, data[:8])
+
+  cs, err := tree.Compare(a, b)
+  if err != nil {
+    t.Fatalf("Compare(): did not expect an error, but got one: %v", err)
+  }
+
+  inputs := []report.Input{
+    { Path: "a", Kind: "directory", Encoding: "raw" },
+    { Path: "b", Kind: "directory", Encoding: "raw" },
+  }
+  r := report.New(inputs, cs)
+
+  var buf bytes.Buffer
+  if err := r.WriteJSON(&buf); err != nil {
+    t.Fatalf("WriteJSON(): did not expect an error, but got one: %v", err)
+  }
+
+  var actual map[string]interface{}
+  if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+    t.Fatalf("WriteJSON(): produced invalid JSON: %v", err)
+  }
+
+  var expected map[string]interface{}
+  json.Unmarshal([]byte(`{
+    "schema_version": 1,
+    "inputs": [
+      {"path": "a", "kind": "directory", "encoding": "raw", "gunzip": false},
+      {"path": "b", "kind": "directory", "encoding": "raw", "gunzip": false}
+    ],
+    "summary": {
+      "added": 1, "removed": 1, "changed": 1,
+      "windows": 6, "differing_windows": 1
+    },
+    "files": [
+      {
+        "path": "added", "status": "added",
+        "b": {"mode": "-rw-r--r--", "size": 8, "digest": {
+          "version": 1, "advance_rate_bits": 1, "window_size_bits": 8,
+          "length_bits": 15, "compression_ratio": 0.25
+        }},
+        "ranges": []
+      }, {
+        "path": "changed", "status": "changed",
+        "a": {"mode": "-rw-r--r--", "size": 40, "digest": {
+          "version": 1, "advance_rate_bits": 1, "window_size_bits": 8,
+          "length_bits": 47, "compression_ratio": 0.15
+        }},
+        "b": {"mode": "-rw-r--r--", "size": 40, "digest": {
+          "version": 1, "advance_rate_bits": 1, "window_size_bits": 8,
+          "length_bits": 47, "compression_ratio": 0.15
+        }},
+        "windows": {"total": 6, "differing": 1},
+        "ranges": [
+          {"digest_bits": {"start": 32, "end": 40}, "source_bytes": {"start": 25, "end": 40}}
+        ]
+      }, {
+        "path": "removed", "status": "removed",
+        "a": {"mode": "-rw-r--r--", "size": 0, "digest": {
+          "version": 1, "advance_rate_bits": 1, "window_size_bits": 8,
+          "length_bits": 0, "compression_ratio": 0
+        }},
+        "ranges": []
+      }
+    ]
+  }`), &expected)
+
+  if !reflect.DeepEqual(actual, expected) {
+    t.Errorf("WriteJSON(): expected\n%v\ngot\n%s", expected, buf.String())
+  }
+}
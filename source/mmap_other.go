@@ -0,0 +1,17 @@
+//go:build !linux
+
+package source
+
+import (
+  "errors"
+  "os"
+)
+
+// mmap always fails here, so files are read instead.
+func mmap(f *os.File, size int) ([]byte, error) {
+  return nil, errors.New("memory mapping isn't supported on this platform")
+}
+
+func munmap(data []byte) error {
+  return nil
+}
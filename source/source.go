@@ -0,0 +1,249 @@
+package source
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/logging"
+  "bytes"
+  "context"
+  "encoding/base64"
+  "encoding/hex"
+  "errors"
+  "io"
+  "io/ioutil"
+  "os"
+  "strconv"
+  "unicode"
+)
+
+// Encoding is the way that an input's bytes are stored.
+type Encoding int
+
+const (
+  Raw Encoding = iota
+  Hex     // hex digits, ignoring whitespace
+  Base64  // standard base64 with or without padding, ignoring whitespace
+  Xxd     // the default output of xxd(1)
+)
+
+var encodingNames = map[Encoding]string {
+  Raw: "raw",
+  Hex: "hex",
+  Base64: "base64",
+  Xxd: "xxd",
+}
+
+func (e Encoding) String() string {
+  if name, ok := encodingNames[e]; ok {
+    return name
+  }
+  return "unknown"
+}
+
+// ParseEncoding returns the encoding with the given name.
+func ParseEncoding(name string) (Encoding, error) {
+  for e, n := range encodingNames {
+    if n == name {
+      return e, nil
+    }
+  }
+  return Raw, errors.New("encoding is not recognized: " + name)
+}
+
+// Decode returns the bytes stored in `raw`.
+func Decode(raw []byte, enc Encoding) ([]byte, error) {
+  switch enc {
+  case Raw:
+    return raw, nil
+  case Hex:
+    return decodeHex(stripSpace(raw))
+  case Base64:
+    return decodeBase64(stripSpace(raw))
+  case Xxd:
+    return decodeXxd(raw)
+  }
+  return nil, errors.New("encoding is not recognized")
+}
+
+// Source is the decoded content of a named input.
+type Source struct {
+  Name string
+  Encoding Encoding
+  data []byte
+  release func() error  // unmaps `data`, if it's mapped
+}
+
+// New wraps bytes that are already decoded.
+func New(name string, data []byte) *Source {
+  return &Source{ Name: name, Encoding: Raw, data: data }
+}
+
+// Open reads and decodes the file `name`. Raw files are opened with Map.
+func Open(name string, enc Encoding) (*Source, error) {
+  return OpenContext(context.Background(), name, enc)
+}
+
+// OpenContext is Open, logging to the context's logger.
+func OpenContext(ctx context.Context, name string, enc Encoding) (*Source, error) {
+  if enc == Raw {
+    return MapContext(ctx, name)
+  }
+
+  raw, err := ioutil.ReadFile(name)
+  if err != nil {
+    return nil, err
+  }
+  data, err := Decode(raw, enc)
+  if err != nil {
+    return nil, errors.New(name + ": " + err.Error())
+  }
+  return &Source{ Name: name, Encoding: enc, data: data }, nil
+}
+
+// Map opens the raw file `name` by mapping it into memory where the platform
+// allows, so that its content is never copied. Otherwise, or when the file
+// can't be mapped, it is read in. The file must not be truncated while the
+// source is open.
+func Map(name string) (*Source, error) {
+  return MapContext(context.Background(), name)
+}
+
+// MapContext is Map, logging to the context's logger.
+func MapContext(ctx context.Context, name string) (*Source, error) {
+  f, err := os.Open(name)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  info, err := f.Stat()
+  if err != nil {
+    return nil, err
+  }
+  size := info.Size()
+
+  if info.Mode().IsRegular() && size > 0 && int64(int(size)) == size {
+    data, err := mmap(f, int(size))
+    if err == nil {
+      release := func() error { return munmap(data) }
+      return &Source{ Name: name, Encoding: Raw, data: data, release: release }, nil
+    }
+    logging.FromContext(ctx).Debug("reading instead of mapping",
+      "name", name, "error", err.Error())
+  }
+
+  var data []byte
+  if info.Mode().IsRegular() && int64(int(size)) == size {
+    data = make([]byte, size)
+    _, err = io.ReadFull(f, data)
+  } else {
+    data, err = ioutil.ReadAll(f)
+  }
+  if err != nil {
+    return nil, err
+  }
+  return New(name, data), nil
+}
+
+// Bytes returns the decoded content. It must not be modified, and may be
+// read-only memory.
+func (s *Source) Bytes() []byte {
+  return s.data
+}
+
+// BitString returns the decoded content as a bit string. The content isn't
+// copied, so the bit string can't be used once the source is closed.
+func (s *Source) BitString() bitstr.BitString {
+  return bitstr.Wrap(s.data)
+}
+
+// Close releases the source. Its bytes must not be used afterwards.
+func (s *Source) Close() error {
+  s.data = nil
+  if s.release == nil {
+    return nil
+  }
+  release := s.release
+  s.release = nil
+  return release()
+}
+
+func stripSpace(raw []byte) []byte {
+  return bytes.Map(func(r rune) rune {
+    if unicode.IsSpace(r) {
+      return -1
+    }
+    return r
+  }, raw)
+}
+
+func decodeHex(raw []byte) ([]byte, error) {
+  out := make([]byte, hex.DecodedLen(len(raw)))
+  n, err := hex.Decode(out, raw)
+  if err != nil {
+    return nil, err
+  }
+  return out[:n], nil
+}
+
+func decodeBase64(raw []byte) ([]byte, error) {
+  enc := base64.StdEncoding
+  if len(raw) % 4 != 0 {
+    enc = base64.RawStdEncoding
+  }
+  out := make([]byte, enc.DecodedLen(len(raw)))
+  n, err := enc.Decode(out, raw)
+  if err != nil {
+    return nil, err
+  }
+  return out[:n], nil
+}
+
+// decodeXxd reads lines like
+//
+//   00000010: 6f72 6c64 0a                             orld.
+//
+// where the hex column ends at the first double space. Each line's offset
+// must follow on from the previous line.
+func decodeXxd(raw []byte) ([]byte, error) {
+  out := []byte{}
+  start := int64(-1)
+
+  for n, line := range bytes.Split(raw, []byte("\n")) {
+    line = bytes.TrimRight(line, "\r")
+    if len(bytes.TrimSpace(line)) == 0 {
+      continue
+    }
+
+    lineErr := func(msg string) error {
+      return errors.New("xxd line " + strconv.Itoa(n + 1) + ": " + msg)
+    }
+
+    colon := bytes.IndexByte(line, ':')
+    if colon < 0 {
+      return nil, lineErr("missing offset")
+    }
+    offset, err := strconv.ParseInt(string(line[:colon]), 16, 64)
+    if err != nil {
+      return nil, lineErr("invalid offset")
+    }
+    if start < 0 {
+      start = offset
+    }
+    if offset != start + int64(len(out)) {
+      return nil, lineErr("offset does not follow the previous line")
+    }
+
+    col := line[colon+1:]
+    if i := bytes.Index(bytes.TrimPrefix(col, []byte(" ")), []byte("  ")); i >= 0 {
+      col = col[:i+1]
+    }
+
+    data, err := decodeHex(stripSpace(col))
+    if err != nil {
+      return nil, lineErr(err.Error())
+    }
+    out = append(out, data...)
+  }
+
+  return out, nil
+}
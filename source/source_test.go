@@ -0,0 +1,198 @@
+package source_test
+
+import(
+  "flag"
+  "os"
+  "testing"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/source"
+  "io/ioutil"
+  "path/filepath"
+  "bytes"
+)
+
+// benchSize can be raised to benchmark huge files, as in
+// `go test -bench . -args -benchsize 4294967296`.
+var benchSize = flag.Int64("benchsize", 64 << 20, "size in bytes of the file used by benchmarks")
+
+func TestParseEncoding(t *testing.T) {
+  for _, name := range []string{ "raw", "hex", "base64", "xxd" } {
+    e, err := source.ParseEncoding(name)
+    if err != nil {
+      t.Fatalf("ParseEncoding(%s): did not expect an error, but got one: %v", name, err)
+    }
+    if e.String() != name {
+      t.Errorf("ParseEncoding(%s): expected to round trip, got %s", name, e)
+    }
+  }
+
+  if _, err := source.ParseEncoding("rot13"); err == nil {
+    t.Errorf("ParseEncoding(rot13): expected an error, but didn't get one")
+  }
+}
+
+func TestDecode(t *testing.T) {
+  hello := []byte("hello, world\n")
+
+  var tbl = []struct {
+    enc source.Encoding
+    in string
+    out []byte
+  }{
+    { source.Raw, "hello, world\n", hello },
+    { source.Hex, "68656c6c6f2c20776f726c640a", hello },
+    { source.Hex, "6865 6c6c 6f2c\n20776f72\r\n6c640a\n", hello },
+    { source.Hex, "", []byte{} },
+    { source.Base64, "aGVsbG8sIHdvcmxkCg==", hello },
+    { source.Base64, "aGVsbG8sIHdv\ncmxkCg", hello },
+    {
+      source.Xxd,
+      "00000000: 6865 6c6c 6f2c 2077 6f72 6c64 0a         hello, world.\n",
+      hello,
+    }, {
+      source.Xxd,
+      "00000000: 6865 6c6c 6f2c 2077  hello, w\n" +
+      "00000008: 6f72 6c64 0a         orld.\n",
+      hello,
+    }, {
+      // An ASCII column holding a double space mustn't be read as hex.
+      source.Xxd,
+      "00000010: 2020 4142                 AB\n",
+      []byte("  AB"),
+    },
+  }
+  for _, e := range tbl {
+    actual, err := source.Decode([]byte(e.in), e.enc)
+    if err != nil {
+      t.Fatalf("Decode(%q, %s): did not expect an error, but got one: %v", e.in, e.enc, err)
+    }
+    if !bytes.Equal(actual, e.out) {
+      t.Errorf("Decode(%q, %s): expected %q, got %q", e.in, e.enc, e.out, actual)
+    }
+  }
+
+  var bad = []struct {
+    enc source.Encoding
+    in string
+  }{
+    { source.Hex, "6865 6" },
+    { source.Hex, "zz" },
+    { source.Base64, "a$==" },
+    { source.Xxd, "6865 6c6c" },
+    { source.Xxd, "00000000: 6865  he\n00000004: 6c6c  ll\n" },
+  }
+  for _, e := range bad {
+    if _, err := source.Decode([]byte(e.in), e.enc); err == nil {
+      t.Errorf("Decode(%q, %s): expected an error, but didn't get one", e.in, e.enc)
+    }
+  }
+}
+
+func TestOpen(t *testing.T) {
+  name := filepath.Join(t.TempDir(), "input.txt")
+  ioutil.WriteFile(name, []byte("ff00\n"), 0644)
+
+  s, err := source.Open(name, source.Hex)
+  if err != nil {
+    t.Fatalf("Open(): did not expect an error, but got one: %v", err)
+  }
+  defer s.Close()
+
+  if !bytes.Equal(s.Bytes(), []byte{ 0xff, 0x00 }) {
+    t.Errorf("Open(): expected ff00, got %02x", s.Bytes())
+  }
+  if l := s.BitString().Length().Int64(); l != 16 {
+    t.Errorf("BitString(): expected a length of 16, got %d", l)
+  }
+
+  if _, err := source.Open(name, source.Xxd); err == nil {
+    t.Errorf("Open(): expected a decoding error, but didn't get one")
+  }
+}
+
+func TestMap(t *testing.T) {
+  dir := t.TempDir()
+
+  for _, data := range [][]byte{ {}, []byte("mizudiff"), make([]byte, 1 << 16) } {
+    name := filepath.Join(dir, "input")
+    ioutil.WriteFile(name, data, 0644)
+
+    s, err := source.Map(name)
+    if err != nil {
+      t.Fatalf("Map(): did not expect an error, but got one: %v", err)
+    }
+    if !bytes.Equal(s.Bytes(), data) {
+      t.Errorf("Map(): expected %d bytes of content, got %d", len(data), len(s.Bytes()))
+    }
+    if l := s.BitString().Length().Int64(); l != int64(len(data)) * 8 {
+      t.Errorf("BitString(): expected a length of %d, got %d", len(data) * 8, l)
+    }
+    if err := s.Close(); err != nil {
+      t.Errorf("Close(): did not expect an error, but got one: %v", err)
+    }
+    if s.Bytes() != nil {
+      t.Errorf("Close(): expected the content to be released")
+    }
+  }
+
+  if _, err := source.Map(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+    t.Errorf("Map(): expected a missing file error, got %v", err)
+  }
+}
+
+// benchFile creates a sparse file of benchSize bytes.
+func benchFile(b *testing.B) string {
+  name := filepath.Join(b.TempDir(), "input")
+  f, err := os.Create(name)
+  if err != nil {
+    b.Fatal(err)
+  }
+  defer f.Close()
+  if err := f.Truncate(*benchSize); err != nil {
+    b.Fatal(err)
+  }
+  return name
+}
+
+// touch reads a byte from every page so that the whole input gets loaded.
+func touch(data []byte) byte {
+  x := byte(0)
+  for i := 0; i < len(data); i += 4096 {
+    x ^= data[i]
+  }
+  return x
+}
+
+func BenchmarkMap(b *testing.B) {
+  name := benchFile(b)
+  b.SetBytes(*benchSize)
+  b.ReportAllocs()
+  b.ResetTimer()
+
+  for i := 0; i < b.N; i++ {
+    s, err := source.Map(name)
+    if err != nil {
+      b.Fatal(err)
+    }
+    touch(s.Bytes())
+    s.BitString()
+    s.Close()
+  }
+}
+
+// BenchmarkReadFile loads the input the way Map replaces, for comparison.
+func BenchmarkReadFile(b *testing.B) {
+  name := benchFile(b)
+  b.SetBytes(*benchSize)
+  b.ReportAllocs()
+  b.ResetTimer()
+
+  for i := 0; i < b.N; i++ {
+    data, err := ioutil.ReadFile(name)
+    if err != nil {
+      b.Fatal(err)
+    }
+    touch(data)
+    bitstr.New(data)
+  }
+}
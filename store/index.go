@@ -0,0 +1,356 @@
+package store
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "math"
+  "os"
+  "path/filepath"
+  "sort"
+)
+
+// The MinHash signature of a digest is split into bands of rows for
+// locality-sensitive hashing. Two digests whose shingles have a Jaccard
+// similarity of about (1/bands)^(1/rows), which is 0.5 here, or more are
+// likely to share a band.
+const (
+  bands = 16
+  rows = 4
+
+  // shingleSize is how many bytes of digest data make up a shingle.
+  shingleSize = 4
+)
+
+// IndexMagic begins the serialized index.
+var IndexMagic = []byte("MZDI")
+
+// indexFile is the name of the index within the store directory.
+const indexFile = "index"
+
+// Signature is the MinHash signature of a digest's data.
+type Signature [bands * rows]uint64
+
+// Sign returns the MinHash signature of the set of shingles in the digest's
+// data, each shingle being a run of bytes.
+func Sign(d digest.Digest) Signature {
+  var sig Signature
+  for i := range sig {
+    sig[i] = math.MaxUint64
+  }
+
+  data := d.Data.WithOrder(bitstr.MSBFirst).Bytes()
+
+  // Data shorter than a shingle is a shingle of its own.
+  n := len(data) - shingleSize + 1
+  if n < 1 && len(data) > 0 {
+    n = 1
+  }
+
+  for i := 0; i < n; i++ {
+    var shingle [8]byte
+    copy(shingle[8 - shingleSize:], data[i:])
+    x := binary.BigEndian.Uint64(shingle[:])
+
+    for j := range sig {
+      if h := mix(x ^ seeds[j]); h < sig[j] {
+        sig[j] = h
+      }
+    }
+  }
+  return sig
+}
+
+// Similarity estimates the Jaccard similarity of the shingles behind two
+// signatures.
+func (sig Signature) Similarity(other Signature) float64 {
+  same := 0
+  for i := range sig {
+    if sig[i] == other[i] {
+      same++
+    }
+  }
+  return float64(same) / float64(len(sig))
+}
+
+// band hashes the rows of one band of the signature.
+func (sig Signature) band(b int) uint64 {
+  h := uint64(b)
+  for _, v := range sig[b * rows:(b + 1) * rows] {
+    h = mix(h ^ v)
+  }
+  return h
+}
+
+// seeds picks a different hash function for each row of a signature.
+var seeds = func() [bands * rows]uint64 {
+  var s [bands * rows]uint64
+  x := uint64(0x6d697a7564696666)
+  for i := range s {
+    x = mix(x)
+    s[i] = x
+  }
+  return s
+}()
+
+// mix is the splitmix64 finalizer.
+func mix(x uint64) uint64 {
+  x += 0x9e3779b97f4a7c15
+  x = (x ^ x >> 30) * 0xbf58476d1ce4e5b9
+  x = (x ^ x >> 27) * 0x94d049bb133111eb
+  return x ^ x >> 31
+}
+
+// Index finds stored digests that are likely to resemble a given one
+// without comparing against all of them.
+type Index struct {
+  sigs map[ID]Signature
+  buckets [bands]map[uint64][]ID
+}
+
+func NewIndex() *Index {
+  x := &Index{ sigs: map[ID]Signature{} }
+  for b := range x.buckets {
+    x.buckets[b] = map[uint64][]ID{}
+  }
+  return x
+}
+
+// Len returns how many digests are indexed.
+func (x *Index) Len() int {
+  return len(x.sigs)
+}
+
+// Add indexes a digest's signature under its ID.
+func (x *Index) Add(id ID, sig Signature) {
+  if _, ok := x.sigs[id]; ok {
+    x.Remove(id)
+  }
+  x.sigs[id] = sig
+  for b := range x.buckets {
+    k := sig.band(b)
+    x.buckets[b][k] = append(x.buckets[b][k], id)
+  }
+}
+
+// Remove drops the digest with the given ID from the index.
+func (x *Index) Remove(id ID) {
+  sig, ok := x.sigs[id]
+  if !ok {
+    return
+  }
+  delete(x.sigs, id)
+  for b := range x.buckets {
+    k := sig.band(b)
+    ids := x.buckets[b][k]
+    for i := range ids {
+      if ids[i] == id {
+        ids = append(ids[:i], ids[i+1:]...)
+        break
+      }
+    }
+    if len(ids) == 0 {
+      delete(x.buckets[b], k)
+    } else {
+      x.buckets[b][k] = ids
+    }
+  }
+}
+
+// Candidates returns the IDs that share at least one band with `sig`,
+// most similar first by their estimated similarity.
+func (x *Index) Candidates(sig Signature) []ID {
+  seen := map[ID]bool{}
+  ids := []ID{}
+  for b := range x.buckets {
+    for _, id := range x.buckets[b][sig.band(b)] {
+      if !seen[id] {
+        seen[id] = true
+        ids = append(ids, id)
+      }
+    }
+  }
+
+  sort.Slice(ids, func(i, j int) bool {
+    si, sj := sig.Similarity(x.sigs[ids[i]]), sig.Similarity(x.sigs[ids[j]])
+    if si != sj {
+      return si > sj
+    }
+    return bytes.Compare(ids[i][:], ids[j][:]) < 0
+  })
+  return ids
+}
+
+// Bytes serializes the index: the magic, the version and the number of
+// signatures, followed by each ID with its signature.
+func (x *Index) Bytes() []byte {
+  ids := make([]ID, 0, len(x.sigs))
+  for id := range x.sigs {
+    ids = append(ids, id)
+  }
+  sort.Slice(ids, func(i, j int) bool {
+    return bytes.Compare(ids[i][:], ids[j][:]) < 0
+  })
+
+  var buf bytes.Buffer
+  buf.Write(IndexMagic)
+  head := make([]byte, 4 + 4)
+  binary.BigEndian.PutUint32(head[0:4], CurrentVersion)
+  binary.BigEndian.PutUint32(head[4:8], uint32(len(ids)))
+  buf.Write(head)
+
+  for _, id := range ids {
+    buf.Write(id[:])
+    for _, v := range x.sigs[id] {
+      binary.Write(&buf, binary.BigEndian, v)
+    }
+  }
+  return buf.Bytes()
+}
+
+// LoadIndex parses a serialized index.
+func LoadIndex(raw []byte) (*Index, error) {
+  if !bytes.HasPrefix(raw, IndexMagic) {
+    return nil, fmt.Errorf("%w: index does not begin with the index magic", ErrCorrupt)
+  }
+  raw = raw[len(IndexMagic):]
+
+  if len(raw) < 8 {
+    return nil, fmt.Errorf("%w: index is too short to contain a header", ErrCorrupt)
+  }
+  if binary.BigEndian.Uint32(raw[0:4]) != CurrentVersion {
+    return nil, fmt.Errorf("%w: index version is not recognized", ErrCorrupt)
+  }
+  count := uint64(binary.BigEndian.Uint32(raw[4:8]))
+  raw = raw[8:]
+
+  size := uint64(len(ID{}) + 8 * len(Signature{}))
+  if uint64(len(raw)) != count * size {
+    return nil, fmt.Errorf("%w: index length doesn't match its count", ErrCorrupt)
+  }
+
+  x := NewIndex()
+  for ; len(raw) > 0; raw = raw[size:] {
+    var id ID
+    var sig Signature
+    copy(id[:], raw)
+    for i := range sig {
+      sig[i] = binary.BigEndian.Uint64(raw[len(id) + 8 * i:])
+    }
+    x.Add(id, sig)
+  }
+  return x, nil
+}
+
+// Index loads the store's index, bringing it up to date with the entries
+// in the store and saving it if that changed it. A missing or corrupt index
+// is rebuilt.
+func (s *Store) Index() (*Index, error) {
+  p := filepath.Join(s.Dir, indexFile)
+
+  x := NewIndex()
+  raw, err := ioutil.ReadFile(p)
+  switch {
+  case err == nil:
+    if loaded, err := LoadIndex(raw); err == nil {
+      x = loaded
+    } else {
+      s.log().Warn("rebuilding the index", "error", err.Error())
+    }
+  case !os.IsNotExist(err):
+    return nil, err
+  }
+
+  changed := false
+  present := map[ID]bool{}
+  err = s.walk(func(id ID, p string) error {
+    present[id] = true
+    if _, ok := x.sigs[id]; ok {
+      return nil
+    }
+    e, err := s.load(id, p)
+    if errors.Is(err, ErrCorrupt) {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    x.Add(id, Sign(e.Digest))
+    changed = true
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  for id := range x.sigs {
+    if !present[id] {
+      x.Remove(id)
+      changed = true
+    }
+  }
+
+  if changed {
+    s.log().Debug("index updated", "digests", x.Len())
+    if err := s.writeFile(p, x.Bytes()); err != nil {
+      return nil, err
+    }
+  }
+  return x, nil
+}
+
+// Match is a stored digest ranked by its distance from another digest.
+type Match struct {
+  Entry Entry
+  Distance float64  // as with digest.Distance
+}
+
+// Nearest returns up to `n` of the stored digests that are closest to `d`,
+// closest first. Only the digests that the index suggests are compared, so
+// a digest that resembles `d` only slightly may be missed.
+func (s *Store) Nearest(d digest.Digest, n int) ([]Match, error) {
+  x, err := s.Index()
+  if err != nil {
+    return nil, err
+  }
+  ids := x.Candidates(Sign(d))
+  s.log().Info("index searched", "digests", x.Len(), "candidates", len(ids))
+  return s.Rank(d, ids, n)
+}
+
+// Rank compares `d` with every stored digest in `ids` and returns up to `n`
+// of them, closest first. A negative `n` returns them all. Digests that are
+// gone or corrupt are skipped, since the index may be out of date.
+func (s *Store) Rank(d digest.Digest, ids []ID, n int) ([]Match, error) {
+  ms := []Match{}
+  for _, id := range ids {
+    e, err := s.Peek(id)
+    if errors.Is(err, ErrNotFound) || errors.Is(err, ErrCorrupt) {
+      s.log().Warn("skipping stored entry", "id", id.String(), "error", err.Error())
+      continue
+    }
+    if err != nil {
+      return nil, err
+    }
+    if !digest.Compatible(e.Digest.Version, d.Version) {
+      continue
+    }
+    dist, err := digest.Distance(d, e.Digest)
+    if err != nil {
+      return nil, err
+    }
+    ms = append(ms, Match{ e, dist })
+  }
+
+  sort.SliceStable(ms, func(i, j int) bool {
+    return ms[i].Distance < ms[j].Distance
+  })
+  if n >= 0 && len(ms) > n {
+    ms = ms[:n]
+  }
+  return ms, nil
+}
@@ -0,0 +1,448 @@
+package store
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/logging"
+  "bytes"
+  "crypto/sha256"
+  "encoding/binary"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+// Magic begins every stored entry.
+var Magic = []byte("MZDS")
+
+const CurrentVersion = 0x0
+
+var (
+  // ErrNotFound is returned when no stored digest has the requested ID.
+  ErrNotFound = errors.New("digest is not in the store")
+
+  // ErrAmbiguous is returned when an ID prefix matches several digests.
+  ErrAmbiguous = errors.New("digest ID prefix is ambiguous")
+
+  // ErrCorrupt is returned when a stored entry can't be parsed.
+  ErrCorrupt = errors.New("stored entry is corrupt")
+)
+
+// ID is the SHA-256 of a digest's source, which names it in the store.
+type ID [sha256.Size]byte
+
+// Sum returns the ID of the digest of `data`.
+func Sum(data []byte) ID {
+  return ID(sha256.Sum256(data))
+}
+
+func (id ID) String() string {
+  return hex.EncodeToString(id[:])
+}
+
+// Meta describes the source that a stored digest was made from.
+type Meta struct {
+  Name string  // base name of the source when it was added
+  Mode os.FileMode
+  Size int64
+  Added time.Time
+}
+
+// Entry is a stored digest.
+type Entry struct {
+  ID ID
+  Meta Meta
+  Digest digest.Digest
+}
+
+// Bytes serializes the entry as it is kept in the store: the magic, the
+// version, the metadata block and then the digest.
+func (e Entry) Bytes() ([]byte, error) {
+  if len(e.Meta.Name) > 0xffff {
+    return nil, errors.New("stored entry name is too long")
+  }
+  d, err := e.Digest.Bytes()
+  if err != nil {
+    return nil, err
+  }
+
+  var buf bytes.Buffer
+  buf.Write(Magic)
+
+  head := make([]byte, 4 + 4 + 8 + 8 + 2)
+  binary.BigEndian.PutUint32(head[0:4], CurrentVersion)
+  binary.BigEndian.PutUint32(head[4:8], uint32(e.Meta.Mode))
+  binary.BigEndian.PutUint64(head[8:16], uint64(e.Meta.Size))
+  binary.BigEndian.PutUint64(head[16:24], uint64(e.Meta.Added.UnixNano()))
+  binary.BigEndian.PutUint16(head[24:26], uint16(len(e.Meta.Name)))
+  buf.Write(head)
+  buf.WriteString(e.Meta.Name)
+  buf.Write(d)
+
+  return buf.Bytes(), nil
+}
+
+// Load parses a stored entry. The ID isn't recorded in the entry itself, so
+// it's left for the caller to fill in.
+func Load(raw []byte) (Entry, error) {
+  if !bytes.HasPrefix(raw, Magic) {
+    return Entry{}, fmt.Errorf("%w: it does not begin with the store magic", ErrCorrupt)
+  }
+  raw = raw[len(Magic):]
+
+  if len(raw) < 26 {
+    return Entry{}, fmt.Errorf("%w: too short to contain a header", ErrCorrupt)
+  }
+  if binary.BigEndian.Uint32(raw[0:4]) != CurrentVersion {
+    return Entry{}, fmt.Errorf("%w: version is not recognized", ErrCorrupt)
+  }
+
+  e := Entry{}
+  e.Meta.Mode = os.FileMode(binary.BigEndian.Uint32(raw[4:8]))
+  e.Meta.Size = int64(binary.BigEndian.Uint64(raw[8:16]))
+  e.Meta.Added = time.Unix(0, int64(binary.BigEndian.Uint64(raw[16:24])))
+  n := int(binary.BigEndian.Uint16(raw[24:26])) + 26
+  if len(raw) < n {
+    return Entry{}, fmt.Errorf("%w: too short to contain its name", ErrCorrupt)
+  }
+  e.Meta.Name = string(raw[26:n])
+
+  d, err := digest.LoadOptions{ Strict: true }.Load(raw[n:])
+  if err != nil {
+    return Entry{}, fmt.Errorf("%w: %v", ErrCorrupt, err)
+  }
+  e.Digest = d
+  return e, nil
+}
+
+// Store is a directory of digests named by the SHA-256 of their source.
+// Entries are spread over subdirectories named after the first byte of
+// their ID, in hex.
+type Store struct {
+  Dir string
+
+  // Logger is where the store's work is logged. Nil logs nothing.
+  Logger *slog.Logger
+}
+
+// Open opens the store in `dir`, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return nil, err
+  }
+  return &Store{ Dir: dir }, nil
+}
+
+func (s *Store) log() *slog.Logger {
+  return logging.OrDiscard(s.Logger)
+}
+
+func (s *Store) path(id ID) string {
+  h := id.String()
+  return filepath.Join(s.Dir, h[:2], h[2:])
+}
+
+// Add digests `data` and stores the digest under the ID of `data`. If it's
+// already stored, the stored entry is returned instead.
+func (s *Store) Add(name string, mode os.FileMode, data []byte) (Entry, error) {
+  id := Sum(data)
+  if e, err := s.Get(id); err == nil {
+    return e, nil
+  }
+
+  d, err := digest.New(bitstr.Wrap(data))
+  if err != nil {
+    return Entry{}, err
+  }
+  e := Entry{ id, Meta{ filepath.Base(name), mode, int64(len(data)), time.Now() }, d }
+  return e, s.Put(e)
+}
+
+// Put stores an entry that has already been made, replacing any entry with
+// the same ID.
+func (s *Store) Put(e Entry) error {
+  raw, err := e.Bytes()
+  if err != nil {
+    return err
+  }
+
+  p := s.path(e.ID)
+  if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+    return err
+  }
+  if err := s.writeFile(p, raw); err != nil {
+    return err
+  }
+  s.log().Debug("digest stored", "id", e.ID.String(), "name", e.Meta.Name)
+  return nil
+}
+
+// writeFile writes to a temporary file first and renames it to `p`, so
+// that a reader never sees a partial file. GC cleans up any temporary files
+// that are left behind.
+func (s *Store) writeFile(p string, raw []byte) error {
+  f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(f.Name())
+
+  if _, err := f.Write(raw); err != nil {
+    f.Close()
+    return err
+  }
+  if err := f.Close(); err != nil {
+    return err
+  }
+  return os.Rename(f.Name(), p)
+}
+
+// Get returns the entry stored under `id`. Getting an entry counts as using
+// it, which keeps it from GC.
+func (s *Store) Get(id ID) (Entry, error) {
+  p := s.path(id)
+  raw, err := ioutil.ReadFile(p)
+  if os.IsNotExist(err) {
+    return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+  }
+  if err != nil {
+    return Entry{}, err
+  }
+
+  e, err := Load(raw)
+  if err != nil {
+    return Entry{}, fmt.Errorf("%s: %w", id, err)
+  }
+  e.ID = id
+
+  now := time.Now()
+  os.Chtimes(p, now, now)
+  return e, nil
+}
+
+// Peek is Get without counting as a use of the entry.
+func (s *Store) Peek(id ID) (Entry, error) {
+  e, err := s.load(id, s.path(id))
+  if os.IsNotExist(err) {
+    return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+  }
+  if err != nil {
+    return Entry{}, fmt.Errorf("%s: %w", id, err)
+  }
+  return e, nil
+}
+
+// IDs returns the IDs of every entry in the store, sorted, without reading
+// the entries.
+func (s *Store) IDs() ([]ID, error) {
+  ids := []ID{}
+  err := s.walk(func(id ID, p string) error {
+    ids = append(ids, id)
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+  sort.Slice(ids, func(i, j int) bool {
+    return bytes.Compare(ids[i][:], ids[j][:]) < 0
+  })
+  return ids, nil
+}
+
+// Resolve finds the ID that begins with the hex digits in `prefix`.
+func (s *Store) Resolve(prefix string) (ID, error) {
+  prefix = strings.ToLower(prefix)
+  if len(prefix) < 2 || len(prefix) > 2 * len(ID{}) ||
+    strings.Trim(prefix, "0123456789abcdef") != "" {
+    return ID{}, fmt.Errorf("%w: %s", ErrNotFound, prefix)
+  }
+
+  names, err := ioutil.ReadDir(filepath.Join(s.Dir, prefix[:2]))
+  if err != nil && !os.IsNotExist(err) {
+    return ID{}, err
+  }
+
+  matches := []ID{}
+  for _, info := range names {
+    id, ok := parseID(prefix[:2], info.Name())
+    if ok && strings.HasPrefix(id.String(), prefix) {
+      matches = append(matches, id)
+    }
+  }
+
+  switch len(matches) {
+  case 0:
+    return ID{}, fmt.Errorf("%w: %s", ErrNotFound, prefix)
+  case 1:
+    return matches[0], nil
+  }
+  return ID{}, fmt.Errorf("%w: %s", ErrAmbiguous, prefix)
+}
+
+// List returns every readable entry in the store, sorted by ID.
+func (s *Store) List() ([]Entry, error) {
+  es := []Entry{}
+  err := s.walk(func(id ID, p string) error {
+    e, err := s.load(id, p)
+    if err != nil {
+      s.log().Warn("skipping stored entry", "id", id.String(), "error", err.Error())
+      return nil
+    }
+    es = append(es, e)
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  sort.Slice(es, func(i, j int) bool {
+    return bytes.Compare(es[i].ID[:], es[j].ID[:]) < 0
+  })
+  return es, nil
+}
+
+// GC removes temporary files and corrupt entries left in the store, along
+// with entries that were last used before `before`. A zero `before` keeps
+// every readable entry. It returns how many files were removed.
+func (s *Store) GC(before time.Time) (int, error) {
+  removed := 0
+  remove := func(p string) error {
+    if err := os.Remove(p); err != nil {
+      return err
+    }
+    removed++
+    return nil
+  }
+
+  err := filepath.Walk(s.Dir, func(p string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    if info.IsDir() {
+      return nil
+    }
+
+    rel, err := filepath.Rel(s.Dir, p)
+    if err != nil {
+      return err
+    }
+    dir, name := filepath.Split(rel)
+    id, ok := parseID(filepath.Clean(dir), name)
+
+    switch {
+    case !ok:
+      // Only clean up what the store left behind.
+      if strings.HasPrefix(name, ".tmp-") {
+        return remove(p)
+      }
+    case !before.IsZero() && info.ModTime().Before(before):
+      return remove(p)
+    default:
+      if _, err := s.load(id, p); errors.Is(err, ErrCorrupt) {
+        return remove(p)
+      }
+    }
+    return nil
+  })
+  return removed, err
+}
+
+// Upgrade rewrites every stored digest that isn't already the given version,
+// as with digest.Upgrade, and returns how many it rewrote and how many it
+// skipped because they can't be upgraded without their sources. Rewriting
+// an entry doesn't count as using it. Corrupt entries are left for GC.
+func (s *Store) Upgrade(version uint32) (int, int, error) {
+  n, skipped := 0, 0
+  err := s.walk(func(id ID, p string) error {
+    e, err := s.load(id, p)
+    if errors.Is(err, ErrCorrupt) {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    if e.Digest.Version == version {
+      return nil
+    }
+
+    e.Digest, err = digest.Upgrade(e.Digest, version)
+    if errors.Is(err, digest.ErrNotUpgradable) {
+      s.log().Warn("skipping stored entry", "id", id.String(), "error", err.Error())
+      skipped++
+      return nil
+    }
+    if err != nil {
+      return fmt.Errorf("%s: %w", id, err)
+    }
+    info, err := os.Stat(p)
+    if err != nil {
+      return err
+    }
+    if err := s.Put(e); err != nil {
+      return err
+    }
+    if err := os.Chtimes(p, info.ModTime(), info.ModTime()); err != nil {
+      return err
+    }
+    n++
+    return nil
+  })
+  return n, skipped, err
+}
+
+// walk calls `f` with every entry file in the store.
+func (s *Store) walk(f func(id ID, p string) error) error {
+  dirs, err := ioutil.ReadDir(s.Dir)
+  if err != nil {
+    return err
+  }
+  for _, dir := range dirs {
+    if !dir.IsDir() {
+      continue
+    }
+    names, err := ioutil.ReadDir(filepath.Join(s.Dir, dir.Name()))
+    if err != nil {
+      return err
+    }
+    for _, info := range names {
+      id, ok := parseID(dir.Name(), info.Name())
+      if !ok {
+        continue
+      }
+      if err := f(id, filepath.Join(s.Dir, dir.Name(), info.Name())); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// load reads an entry without marking it as used.
+func (s *Store) load(id ID, p string) (Entry, error) {
+  raw, err := ioutil.ReadFile(p)
+  if err != nil {
+    return Entry{}, err
+  }
+  e, err := Load(raw)
+  if err != nil {
+    return Entry{}, err
+  }
+  e.ID = id
+  return e, nil
+}
+
+// parseID recovers an ID from the names of an entry's directory and file.
+func parseID(dir, name string) (ID, bool) {
+  b, err := hex.DecodeString(dir + name)
+  if err != nil || len(b) != len(ID{}) {
+    return ID{}, false
+  }
+  return ID(b), true
+}
@@ -0,0 +1,275 @@
+package store_test
+
+import(
+  "bytes"
+  "context"
+  "errors"
+  "io/ioutil"
+  "math/rand"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+  "time"
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/store"
+)
+
+func TestAdd(t *testing.T) {
+  s, err := store.Open(filepath.Join(t.TempDir(), "store"))
+  if err != nil {
+    t.Fatalf("Open(): did not expect an error, but got one: %v", err)
+  }
+
+  data := []byte("the quick brown fox jumps over the lazy dog")
+  e, err := s.Add("dir/fox.txt", 0644, data)
+  if err != nil {
+    t.Fatalf("Add(): did not expect an error, but got one: %v", err)
+  }
+  if e.ID != store.Sum(data) {
+    t.Errorf("Add(): expected ID %s, got %s", store.Sum(data), e.ID)
+  }
+  if e.Meta.Name != "fox.txt" || e.Meta.Size != int64(len(data)) || e.Meta.Mode != 0644 {
+    t.Errorf("Add(): unexpected metadata %+v", e.Meta)
+  }
+
+  again, err := s.Add("other.txt", 0600, data)
+  if err != nil {
+    t.Fatalf("Add(): did not expect an error, but got one: %v", err)
+  }
+  if again.Meta.Name != "fox.txt" {
+    t.Errorf("Add(): expected the stored entry to be reused, got %+v", again.Meta)
+  }
+
+  got, err := s.Get(e.ID)
+  if err != nil {
+    t.Fatalf("Get(): did not expect an error, but got one: %v", err)
+  }
+  if !reflect.DeepEqual(got.Digest, e.Digest) {
+    t.Errorf("Get(): expected digest %v, got %v", e.Digest, got.Digest)
+  }
+  if !got.Meta.Added.Equal(e.Meta.Added) {
+    t.Errorf("Get(): expected to be added at %v, got %v", e.Meta.Added, got.Meta.Added)
+  }
+
+  if _, err := s.Get(store.Sum(nil)); !errors.Is(err, store.ErrNotFound) {
+    t.Errorf("Get(): expected %v, got %v", store.ErrNotFound, err)
+  }
+}
+
+func TestResolve(t *testing.T) {
+  s, _ := store.Open(t.TempDir())
+  e, err := s.Add("a", 0644, []byte("a"))
+  if err != nil {
+    t.Fatalf("Add(): did not expect an error, but got one: %v", err)
+  }
+  h := e.ID.String()
+
+  var tbl = []struct {
+    prefix string
+    err error
+  }{
+    { h, nil },
+    { h[:6], nil },
+    { "zz", store.ErrNotFound },
+    { h[:1], store.ErrNotFound },
+    { "00", store.ErrNotFound },
+  }
+  for _, c := range tbl {
+    id, err := s.Resolve(c.prefix)
+    if !errors.Is(err, c.err) {
+      t.Errorf("Resolve(%q): expected %v, got %v", c.prefix, c.err, err)
+    }
+    if err == nil && id != e.ID {
+      t.Errorf("Resolve(%q): expected %s, got %s", c.prefix, e.ID, id)
+    }
+  }
+}
+
+func TestList(t *testing.T) {
+  s, _ := store.Open(t.TempDir())
+  for _, name := range []string{ "a", "b", "c" } {
+    if _, err := s.Add(name, 0644, []byte(name)); err != nil {
+      t.Fatalf("Add(): did not expect an error, but got one: %v", err)
+    }
+  }
+
+  es, err := s.List()
+  if err != nil {
+    t.Fatalf("List(): did not expect an error, but got one: %v", err)
+  }
+  if len(es) != 3 {
+    t.Fatalf("List(): expected 3 entries, got %d", len(es))
+  }
+  for i := 1; i < len(es); i++ {
+    if es[i-1].ID.String() >= es[i].ID.String() {
+      t.Errorf("List(): entries are not sorted by ID")
+    }
+  }
+}
+
+func TestGC(t *testing.T) {
+  s, _ := store.Open(t.TempDir())
+  kept, _ := s.Add("kept", 0644, []byte("kept"))
+
+  // A corrupt entry and a temporary file left by an interrupted Put.
+  corrupt := store.Sum([]byte("corrupt")).String()
+  ioutil.WriteFile(filepath.Join(s.Dir, kept.ID.String()[:2], ".tmp-1"), []byte{}, 0644)
+  os.MkdirAll(filepath.Join(s.Dir, corrupt[:2]), 0755)
+  ioutil.WriteFile(filepath.Join(s.Dir, corrupt[:2], corrupt[2:]), []byte("MZDS"), 0644)
+
+  n, err := s.GC(time.Time{})
+  if err != nil {
+    t.Fatalf("GC(): did not expect an error, but got one: %v", err)
+  }
+  if n != 2 {
+    t.Errorf("GC(): expected to remove 2 files, removed %d", n)
+  }
+  if _, err := s.Get(kept.ID); err != nil {
+    t.Errorf("GC(): expected %s to be kept, but got %v", kept.ID, err)
+  }
+
+  n, err = s.GC(time.Now().Add(time.Hour))
+  if err != nil {
+    t.Fatalf("GC(): did not expect an error, but got one: %v", err)
+  }
+  if n != 1 {
+    t.Errorf("GC(): expected to remove 1 unused entry, removed %d", n)
+  }
+  if _, err := s.Get(kept.ID); !errors.Is(err, store.ErrNotFound) {
+    t.Errorf("GC(): expected %s to be removed, but got %v", kept.ID, err)
+  }
+}
+
+func TestUpgrade(t *testing.T) {
+  s, _ := store.Open(t.TempDir())
+  e, err := s.Add("fox.txt", 0644, []byte("the quick brown fox jumps over the lazy dog"))
+  if err != nil {
+    t.Fatalf("Add(): did not expect an error, but got one: %v", err)
+  }
+  // Store it as a version 0 digest, as older stores hold them.
+  e.Digest, err = digest.NewVersion(context.Background(), 0x0, bitstr.New([]byte("the quick brown fox jumps over the lazy dog")), nil)
+  if err != nil {
+    t.Fatalf("NewVersion(0): did not expect an error, but got one: %v", err)
+  }
+  if err := s.Put(e); err != nil {
+    t.Fatalf("Put(): did not expect an error, but got one: %v", err)
+  }
+  used := time.Now().Add(-time.Hour).Truncate(time.Second)
+  p := filepath.Join(s.Dir, e.ID.String()[:2], e.ID.String()[2:])
+  os.Chtimes(p, used, used)
+
+  var tbl = []struct {
+    version uint32
+    n, skipped int
+  }{
+    { 0x1, 1, 0 },
+    { 0x1, 0, 0 },
+    // Version 1 digests can't go back to version 0 without their sources.
+    { 0x0, 0, 1 },
+  }
+  for _, e := range tbl {
+    n, skipped, err := s.Upgrade(e.version)
+    if err != nil {
+      t.Fatalf("Upgrade(%d): did not expect an error, but got one: %v", e.version, err)
+    }
+    if n != e.n || skipped != e.skipped {
+      t.Errorf("Upgrade(%d): expected to rewrite %d digests and skip %d, got %d and %d",
+        e.version, e.n, e.skipped, n, skipped)
+    }
+  }
+
+  upgraded, err := s.Peek(e.ID)
+  if err != nil {
+    t.Fatalf("Peek(): did not expect an error, but got one: %v", err)
+  }
+  e.Meta.Added = e.Meta.Added.Round(0)
+  if upgraded.Digest.Version != 0x1 || upgraded.Meta != e.Meta {
+    t.Errorf("Upgrade(): expected version 1 with %+v, got version %d with %+v",
+      e.Meta, upgraded.Digest.Version, upgraded.Meta)
+  }
+  if info, _ := os.Stat(p); !info.ModTime().Equal(used) {
+    t.Errorf("Upgrade(): expected the entry to keep its last use %v, got %v", used, info.ModTime())
+  }
+}
+
+func TestNearest(t *testing.T) {
+  s, _ := store.Open(t.TempDir())
+
+  base := randomBytes(8192, 1)
+  near := append([]byte{}, base...)
+  near[100] ^= 0xff
+  near[5000] ^= 0xff
+
+  for name, data := range map[string][]byte{
+    "near": near,
+    "unrelated": randomBytes(8192, 2),
+    "short": base[:512],
+  } {
+    if _, err := s.Add(name, 0644, data); err != nil {
+      t.Fatalf("Add(): did not expect an error, but got one: %v", err)
+    }
+  }
+
+  query := append([]byte{}, base...)
+  query[3000] ^= 0x01
+  d, _ := digest.New(bitstr.New(query))
+
+  ms, err := s.Nearest(d, 5)
+  if err != nil {
+    t.Fatalf("Nearest(): did not expect an error, but got one: %v", err)
+  }
+  if len(ms) == 0 || ms[0].Entry.Meta.Name != "near" {
+    t.Fatalf("Nearest(): expected \"near\" to come first, got %+v", ms)
+  }
+  if ms[0].Distance <= 0 || ms[0].Distance > 0.01 {
+    t.Errorf("Nearest(): expected a small distance to \"near\", got %v", ms[0].Distance)
+  }
+  for _, m := range ms {
+    if m.Entry.Meta.Name == "unrelated" {
+      t.Errorf("Nearest(): did not expect an unrelated digest to be a candidate")
+    }
+  }
+
+  // The index is saved and reused.
+  raw, err := ioutil.ReadFile(filepath.Join(s.Dir, "index"))
+  if err != nil {
+    t.Fatalf("Nearest(): expected the index to be saved, but got %v", err)
+  }
+  x, err := store.LoadIndex(raw)
+  if err != nil {
+    t.Fatalf("LoadIndex(): did not expect an error, but got one: %v", err)
+  }
+  if x.Len() != 3 || !bytes.Equal(x.Bytes(), raw) {
+    t.Errorf("LoadIndex(): expected to round-trip an index of 3 digests, got %d", x.Len())
+  }
+
+  ids, _ := s.IDs()
+  all, err := s.Rank(d, ids, -1)
+  if err != nil {
+    t.Fatalf("Rank(): did not expect an error, but got one: %v", err)
+  }
+  if len(all) != 3 || all[0].Entry.ID != ms[0].Entry.ID {
+    t.Errorf("Rank(): expected all 3 digests with \"near\" first, got %+v", all)
+  }
+
+  // Missing and corrupt candidates are skipped rather than failing the query.
+  corrupt := store.Sum([]byte("corrupt"))
+  os.MkdirAll(filepath.Join(s.Dir, corrupt.String()[:2]), 0755)
+  ioutil.WriteFile(filepath.Join(s.Dir, corrupt.String()[:2], corrupt.String()[2:]), []byte("MZDS"), 0644)
+  ids = append(ids, corrupt, store.Sum([]byte("missing")))
+  all, err = s.Rank(d, ids, -1)
+  if err != nil {
+    t.Fatalf("Rank(): did not expect an error, but got one: %v", err)
+  }
+  if len(all) != 3 {
+    t.Errorf("Rank(): expected the 3 readable digests, got %+v", all)
+  }
+}
+
+func randomBytes(n int, seed int64) []byte {
+  b := make([]byte, n)
+  rand.New(rand.NewSource(seed)).Read(b)
+  return b
+}
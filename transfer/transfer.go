@@ -0,0 +1,243 @@
+package transfer
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/logging"
+  "github.com/pjrebsch/mizudiff/patch"
+  "bytes"
+  "context"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+)
+
+// Every message is sent as a frame: a one byte type, a four byte big endian
+// payload length and then the payload itself.
+//
+// A pull goes as follows:
+//
+//   client -> server  frameDigest  digest of the client's copy
+//   server -> client  framePatch   patch built from that digest
+//
+// If the patch doesn't produce the server's copy (the digests can miss a
+// change), the client asks for everything instead:
+//
+//   client -> server  frameFull    (empty)
+//   server -> client  frameData    the server's copy
+//
+// The server answers any request it can't handle with frameError.
+const (
+  frameDigest = byte(0x01)
+  framePatch = byte(0x02)
+  frameFull = byte(0x03)
+  frameData = byte(0x04)
+  frameError = byte(0x7f)
+)
+
+const frameHeaderSize = 5
+
+// MaxFrameSize is the longest payload that is sent or accepted, which bounds
+// the size of the files that can be pulled.
+var MaxFrameSize = int64(1 << 30)
+
+// ErrFrameTooLarge is returned for a frame longer than MaxFrameSize.
+var ErrFrameTooLarge = errors.New("frame is larger than allowed")
+
+// Serve answers pulls for `b` on every connection accepted from l, until l
+// is closed.
+func Serve(l net.Listener, b []byte) error {
+  return ServeContext(context.Background(), l, b)
+}
+
+// ServeContext is Serve, logging to the context's logger.
+func ServeContext(ctx context.Context, l net.Listener, b []byte) error {
+  target, err := digest.NewContext(ctx, bitstr.New(b), nil)
+  if err != nil {
+    return err
+  }
+
+  for {
+    conn, err := l.Accept()
+    if err != nil {
+      if errors.Is(err, net.ErrClosed) {
+        return nil
+      }
+      return err
+    }
+
+    go func() {
+      defer conn.Close()
+      log := logging.FromContext(ctx).With("remote", conn.RemoteAddr().String())
+      if err := serve(logging.NewContext(ctx, log), conn, target, b); err != nil {
+        log.Warn("pull failed", "error", err)
+        return
+      }
+      log.Info("pull served")
+    }()
+  }
+}
+
+// ServeConn answers a single pull for `b` on conn.
+func ServeConn(conn io.ReadWriter, b []byte) error {
+  return ServeConnContext(context.Background(), conn, b)
+}
+
+// ServeConnContext is ServeConn, logging to the context's logger.
+func ServeConnContext(ctx context.Context, conn io.ReadWriter, b []byte) error {
+  target, err := digest.NewContext(ctx, bitstr.New(b), nil)
+  if err != nil {
+    return err
+  }
+  return serve(ctx, conn, target, b)
+}
+
+func serve(ctx context.Context, conn io.ReadWriter, target digest.Digest, b []byte) error {
+  log := logging.FromContext(ctx)
+
+  typ, payload, err := readFrame(conn)
+  if err != nil {
+    return err
+  }
+  if typ != frameDigest {
+    return refuse(conn, "expected a digest")
+  }
+
+  opts := digest.LoadOptions{ MaxDataLength: MaxFrameSize, Strict: true, Logger: log }
+  source, err := opts.Load(payload)
+  if err != nil {
+    return refuse(conn, err.Error())
+  }
+
+  p, err := patch.Make(source, target, b)
+  if err != nil {
+    return refuse(conn, err.Error())
+  }
+  log.Debug("sending patch", "hunks", len(p.Hunks))
+  if err := writeFrame(conn, framePatch, p.Bytes()); err != nil {
+    return err
+  }
+
+  typ, _, err = readFrame(conn)
+  if err == io.EOF {
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  if typ != frameFull {
+    return refuse(conn, "expected a request for the full data")
+  }
+  log.Info("patch missed a change, sending the full data")
+  return writeFrame(conn, frameData, b)
+}
+
+func refuse(conn io.Writer, msg string) error {
+  if err := writeFrame(conn, frameError, []byte(msg)); err != nil {
+    return err
+  }
+  return errors.New(msg)
+}
+
+// Pull rebuilds the server's copy on conn from the local copy `a`.
+func Pull(conn io.ReadWriter, a []byte) ([]byte, error) {
+  return PullContext(context.Background(), conn, a)
+}
+
+// PullContext is Pull, logging to the context's logger.
+func PullContext(ctx context.Context, conn io.ReadWriter, a []byte) ([]byte, error) {
+  source, err := digest.NewContext(ctx, bitstr.New(a), nil)
+  if err != nil {
+    return nil, err
+  }
+  raw, err := source.Bytes()
+  if err != nil {
+    return nil, err
+  }
+  if err := writeFrame(conn, frameDigest, raw); err != nil {
+    return nil, err
+  }
+
+  payload, err := expectFrame(conn, framePatch)
+  if err != nil {
+    return nil, err
+  }
+  p, err := patch.Load(payload)
+  if err != nil {
+    return nil, err
+  }
+
+  b, err := patch.Apply(a, p)
+  if err != patch.ErrChecksum {
+    return b, err
+  }
+  logging.FromContext(ctx).Info("patch did not reproduce the server's data, pulling all of it")
+
+  if err := writeFrame(conn, frameFull, nil); err != nil {
+    return nil, err
+  }
+  b, err = expectFrame(conn, frameData)
+  if err != nil {
+    return nil, err
+  }
+
+  // The full copy must still match what the patch promised.
+  full := patch.Patch{
+    Version: p.Version, Length: p.Length, Sum: p.Sum,
+    Hunks: []patch.Hunk{ { Offset: 0, Data: b } },
+  }
+  return patch.Apply(nil, full)
+}
+
+func expectFrame(r io.Reader, want byte) ([]byte, error) {
+  typ, payload, err := readFrame(r)
+  if err != nil {
+    return nil, err
+  }
+  if typ == frameError {
+    return nil, errors.New("server refused the pull: " + string(payload))
+  }
+  if typ != want {
+    return nil, errors.New("received an unexpected frame type")
+  }
+  return payload, nil
+}
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+  if int64(len(payload)) > MaxFrameSize || uint64(len(payload)) > uint64(^uint32(0)) {
+    return ErrFrameTooLarge
+  }
+  head := make([]byte, frameHeaderSize)
+  head[0] = typ
+  binary.BigEndian.PutUint32(head[1:], uint32(len(payload)))
+
+  if _, err := w.Write(head); err != nil {
+    return err
+  }
+  _, err := w.Write(payload)
+  return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+  head := make([]byte, frameHeaderSize)
+  if _, err := io.ReadFull(r, head); err != nil {
+    return 0, nil, err
+  }
+  l := int64(binary.BigEndian.Uint32(head[1:]))
+  if l > MaxFrameSize {
+    return 0, nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, l)
+  }
+
+  // Grow the buffer as the data arrives instead of trusting the length up
+  // front.
+  var buf bytes.Buffer
+  if _, err := io.CopyN(&buf, r, l); err != nil {
+    if err == io.EOF {
+      err = io.ErrUnexpectedEOF
+    }
+    return 0, nil, err
+  }
+  return head[0], buf.Bytes(), nil
+}
@@ -0,0 +1,143 @@
+package transfer_test
+
+import(
+  "errors"
+  "testing"
+  "github.com/pjrebsch/mizudiff/transfer"
+  "math"
+  "math/rand"
+  "io"
+  "net"
+  "path/filepath"
+  "bytes"
+)
+
+func TestPull(t *testing.T) {
+  b := deterministicBytes(2048, 2209113)
+
+  var tbl = []struct {
+    name string
+    a []byte
+  }{
+    {"identical", append([]byte{}, b...)},
+    {"changed", func() []byte {
+      a := append([]byte{}, b...)
+      a[5] ^= 0xff
+      a[1800] ^= 0x01
+      return a
+    }()},
+    {"missing", []byte{}},
+    {"longer", append(append([]byte{}, b...), 0xde, 0xad)},
+    // These two flips cancel out in the digest, so the server's patch won't
+    // cover them and the client has to fall back to the full data.
+    {"collision", func() []byte {
+      a := append([]byte{}, b...)
+      a[0] ^= 0x40
+      a[1] ^= 0x80
+      return a
+    }()},
+  }
+
+  networks := []struct {
+    network, address string
+  }{
+    {"tcp", "127.0.0.1:0"},
+    {"unix", filepath.Join(t.TempDir(), "mizudiff.sock")},
+  }
+  for _, n := range networks {
+    l, err := net.Listen(n.network, n.address)
+    if err != nil {
+      t.Fatalf("Listen(%s): did not expect an error, but got one: %v", n.network, err)
+    }
+
+    done := make(chan error)
+    go func() {
+      done <- transfer.Serve(l, b)
+    }()
+
+    for _, e := range tbl {
+      t.Run(n.network + "/" + e.name, func(t *testing.T) {
+        conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+        if err != nil {
+          t.Fatalf("Dial(): did not expect an error, but got one: %v", err)
+        }
+        defer conn.Close()
+
+        actual, err := transfer.Pull(conn, e.a)
+        if err != nil {
+          t.Fatalf("Pull(): did not expect an error, but got one: %v", err)
+        }
+        if !bytes.Equal(actual, b) {
+          t.Errorf("Pull(): did not rebuild the server's data")
+        }
+      })
+    }
+
+    l.Close()
+    if err := <-done; err != nil {
+      t.Errorf("Serve(): expected to stop cleanly, but got: %v", err)
+    }
+  }
+}
+
+func TestServeConn(t *testing.T) {
+  t.Run("refuses a bad digest", func(t *testing.T) {
+    server, client := net.Pipe()
+    defer client.Close()
+
+    done := make(chan error)
+    go func() {
+      err := transfer.ServeConn(server, []byte{ 0x01 })
+      server.Close()
+      done <- err
+    }()
+
+    // A digest frame holding an unknown version.
+    client.Write([]byte{ 0x01, 0x00, 0x00, 0x00, 0x04, 0x10, 0x00, 0x00, 0x00 })
+
+    head := make([]byte, 5)
+    if _, err := io.ReadFull(client, head); err != nil {
+      t.Fatalf("ReadFull(): did not expect an error, but got one: %v", err)
+    }
+    if head[0] != 0x7f {
+      t.Errorf("ServeConn(): expected an error frame, got type 0x%02x", head[0])
+    }
+    io.Copy(io.Discard, client)
+
+    if err := <-done; err == nil {
+      t.Errorf("ServeConn(): expected an error, but didn't get one")
+    }
+  })
+
+  t.Run("rejects frames over the limit", func(t *testing.T) {
+    server, client := net.Pipe()
+    defer client.Close()
+
+    done := make(chan error)
+    go func() {
+      err := transfer.ServeConn(server, []byte{ 0x01 })
+      server.Close()
+      done <- err
+    }()
+
+    // A digest frame claiming 4 GiB, with nothing after it.
+    client.Write([]byte{ 0x01, 0xff, 0xff, 0xff, 0xff })
+
+    if err := <-done; !errors.Is(err, transfer.ErrFrameTooLarge) {
+      t.Errorf("ServeConn(): expected %v, but got %v", transfer.ErrFrameTooLarge, err)
+    }
+  })
+}
+
+func deterministicBytes(length int, seed int64) []byte {
+  src := rand.NewSource(seed)
+  r := rand.New(src)
+
+  str := make([]byte, length, length)
+
+  for i := 0; i < length; i++ {
+    str[i] = byte(r.Intn(math.MaxUint8))
+  }
+
+  return str
+}
@@ -0,0 +1,125 @@
+package tree
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/logging"
+  "context"
+  "log/slog"
+  "os"
+)
+
+type ChangeKind int
+
+const (
+  Added ChangeKind = iota
+  Removed
+  Changed
+)
+
+func (k ChangeKind) String() string {
+  switch k {
+  case Added:
+    return "added"
+  case Removed:
+    return "removed"
+  case Changed:
+    return "changed"
+  }
+  return "unknown"
+}
+
+// Change describes how a single path differs between two trees. For a
+// changed file, Regions holds the spans of the new file that differ (the
+// whole file when only the content sums differ, or either is unknown) and,
+// when the digests have compatible versions, Spans and Diff hold the window
+// by window comparison that they came from.
+type Change struct {
+  Path string
+  Kind ChangeKind
+  ModeA, ModeB os.FileMode
+  SizeA, SizeB int64
+  DigestA, DigestB digest.Digest
+  Regions []digest.Region
+  Spans []digest.Span
+  Config digest.Config
+  Diff bitstr.BitString
+}
+
+// Compare lists the paths that were added, removed or changed going from
+// tree `a` to tree `b`, in path order.
+func Compare(a, b Tree) ([]Change, error) {
+  return CompareContext(context.Background(), a, b)
+}
+
+// CompareContext is Compare, logging to the context's logger.
+func CompareContext(ctx context.Context, a, b Tree) ([]Change, error) {
+  log := logging.FromContext(ctx)
+  cs := []Change{}
+
+  i, j := 0, 0
+  for i < len(a.Entries) || j < len(b.Entries) {
+    switch {
+    case j == len(b.Entries) ||
+      (i < len(a.Entries) && a.Entries[i].Path < b.Entries[j].Path):
+      e := a.Entries[i]
+      cs = append(cs, Change{ Path: e.Path, Kind: Removed,
+        ModeA: e.Mode, SizeA: e.Size, DigestA: e.Digest })
+      i++
+
+    case i == len(a.Entries) || b.Entries[j].Path < a.Entries[i].Path:
+      e := b.Entries[j]
+      cs = append(cs, Change{ Path: e.Path, Kind: Added,
+        ModeB: e.Mode, SizeB: e.Size, DigestB: e.Digest })
+      j++
+
+    default:
+      ea, eb := a.Entries[i], b.Entries[j]
+      i++
+      j++
+
+      c := Change{ Path: ea.Path, Kind: Changed,
+        ModeA: ea.Mode, ModeB: eb.Mode, SizeA: ea.Size, SizeB: eb.Size,
+        DigestA: ea.Digest, DigestB: eb.Digest }
+
+      if !digest.Compatible(ea.Digest.Version, eb.Digest.Version) {
+        // There's no way to line up the windows, so the whole file counts.
+        c.Regions = []digest.Region{ { Offset: 0, Length: eb.Size } }
+        cs = append(cs, c)
+        continue
+      }
+
+      ss, err := digest.Spans(ea.Digest, eb.Digest)
+      if err != nil {
+        return nil, err
+      }
+      rs := digest.MergeSpans(ss)
+      diff, err := digest.Diff(ea.Digest, eb.Digest)
+      if err != nil {
+        return nil, err
+      }
+      if log.Enabled(ctx, slog.LevelDebug) {
+        log.Debug("digests compared", "path", ea.Path,
+          "windows", diff.Length().String(), "differing", diff.Count())
+      }
+      c.Regions = rs
+      c.Spans = ss
+      c.Config = eb.Digest.Config
+      c.Diff = diff
+
+      same := ea.HasSum() && ea.Sum == eb.Sum
+      if len(rs) == 0 && !same {
+        // The digests can miss a change, and then only the sums tell, but
+        // not where.
+        c.Regions = []digest.Region{ { Offset: 0, Length: eb.Size } }
+      }
+      if len(c.Regions) > 0 || ea.Mode != eb.Mode || ea.Size != eb.Size {
+        cs = append(cs, c)
+      }
+    }
+  }
+
+  log.Info("trees compared",
+    "entries_a", len(a.Entries), "entries_b", len(b.Entries), "changes", len(cs))
+  return cs, nil
+}
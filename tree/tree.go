@@ -0,0 +1,235 @@
+package tree
+
+import(
+  "github.com/pjrebsch/mizudiff/bitstr"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/logging"
+  "bytes"
+  "context"
+  "crypto/sha256"
+  "encoding/binary"
+  "errors"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+)
+
+// Magic begins every serialized tree so it can't be mistaken for a plain
+// digest.
+var Magic = []byte("MZDT")
+
+// CurrentVersion is the version trees are written in. Version 0 trees,
+// which have no content sums, can still be loaded.
+const CurrentVersion = 0x1
+
+// Entry records a single file of a tree.
+type Entry struct {
+  Path string  // slash separated and relative to the root of the tree
+  Mode os.FileMode
+  Size int64
+  Digest digest.Digest
+
+  // Sum is the SHA-256 of the file, or all zeros when it isn't known. The
+  // digest is lossy, so only the sum can show that two files are the same.
+  Sum [sha256.Size]byte
+}
+
+// HasSum reports whether the entry's sum is known.
+func (e Entry) HasSum() bool {
+  return e.Sum != [sha256.Size]byte{}
+}
+
+// Tree holds the digests of a set of files, sorted by path.
+type Tree struct {
+  Entries []Entry
+}
+
+// Add digests `data` and records it under `path`, replacing any entry that
+// is already there.
+func (t *Tree) Add(path string, mode os.FileMode, data []byte) error {
+  return t.AddContext(context.Background(), path, mode, data)
+}
+
+// AddContext is Add, but stops digesting when the context is done.
+func (t *Tree) AddContext(ctx context.Context, path string, mode os.FileMode, data []byte) error {
+  d, err := digest.NewContext(ctx, bitstr.Wrap(data), nil)
+  if err != nil {
+    return err
+  }
+  e := Entry{ filepath.ToSlash(path), mode, int64(len(data)), d, sha256.Sum256(data) }
+
+  i := sort.Search(len(t.Entries), func(i int) bool {
+    return t.Entries[i].Path >= e.Path
+  })
+  if i < len(t.Entries) && t.Entries[i].Path == e.Path {
+    t.Entries[i] = e
+    return nil
+  }
+
+  t.Entries = append(t.Entries, Entry{})
+  copy(t.Entries[i+1:], t.Entries[i:])
+  t.Entries[i] = e
+  return nil
+}
+
+// Find returns the entry recorded under `path`.
+func (t Tree) Find(path string) (Entry, bool) {
+  i := sort.Search(len(t.Entries), func(i int) bool {
+    return t.Entries[i].Path >= path
+  })
+  if i < len(t.Entries) && t.Entries[i].Path == path {
+    return t.Entries[i], true
+  }
+  return Entry{}, false
+}
+
+// Walk digests every regular file under `root`. Symbolic links are recorded
+// with their target as their data rather than being followed.
+func Walk(root string) (Tree, error) {
+  return WalkContext(context.Background(), root)
+}
+
+// WalkContext is Walk, but gives up with the context's error once the
+// context is done. It logs to the context's logger.
+func WalkContext(ctx context.Context, root string) (Tree, error) {
+  t := Tree{ []Entry{} }
+
+  err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+
+    rel, err := filepath.Rel(root, path)
+    if err != nil {
+      return err
+    }
+
+    switch {
+    case info.Mode().IsRegular():
+      data, err := ioutil.ReadFile(path)
+      if err != nil {
+        return err
+      }
+      logging.FromContext(ctx).Debug("digesting file", "path", rel, "size", len(data))
+      return t.AddContext(ctx, rel, info.Mode(), data)
+
+    case info.Mode() & os.ModeSymlink != 0:
+      target, err := os.Readlink(path)
+      if err != nil {
+        return err
+      }
+      return t.AddContext(ctx, rel, info.Mode(), []byte(target))
+    }
+    return nil
+  })
+  if err != nil {
+    return Tree{}, err
+  }
+  return t, nil
+}
+
+// Bytes serializes the tree.
+func (t Tree) Bytes() ([]byte, error) {
+  var buf bytes.Buffer
+
+  buf.Write(Magic)
+  head := make([]byte, 4 + 4)
+  binary.BigEndian.PutUint32(head[0:4], CurrentVersion)
+  binary.BigEndian.PutUint32(head[4:8], uint32(len(t.Entries)))
+  buf.Write(head)
+
+  for _, e := range t.Entries {
+    if len(e.Path) > 0xffff {
+      return nil, errors.New("tree entry path is too long")
+    }
+    d, err := e.Digest.Bytes()
+    if err != nil {
+      return nil, err
+    }
+
+    entry := make([]byte, 2 + len(e.Path) + 4 + 8 + sha256.Size + 4)
+    n := copy(entry[2:], e.Path) + 2
+    binary.BigEndian.PutUint16(entry[0:2], uint16(len(e.Path)))
+    binary.BigEndian.PutUint32(entry[n:n+4], uint32(e.Mode))
+    binary.BigEndian.PutUint64(entry[n+4:n+12], uint64(e.Size))
+    copy(entry[n+12:], e.Sum[:])
+    n += sha256.Size
+    binary.BigEndian.PutUint32(entry[n+12:n+16], uint32(len(d)))
+    buf.Write(entry)
+    buf.Write(d)
+  }
+
+  return buf.Bytes(), nil
+}
+
+// IsTree reports whether `raw` looks like a serialized tree.
+func IsTree(raw []byte) bool {
+  return bytes.HasPrefix(raw, Magic)
+}
+
+// Load parses a serialized tree.
+func Load(raw []byte) (Tree, error) {
+  if !IsTree(raw) {
+    return Tree{}, errors.New("tree data does not begin with the tree magic")
+  }
+  raw = raw[len(Magic):]
+
+  if len(raw) < 8 {
+    return Tree{}, errors.New("tree data is too short to contain a header")
+  }
+  version := binary.BigEndian.Uint32(raw[0:4])
+  if version > CurrentVersion {
+    return Tree{}, errors.New("tree version is not recognized")
+  }
+  count := binary.BigEndian.Uint32(raw[4:8])
+  raw = raw[8:]
+
+  t := Tree{ []Entry{} }
+
+  for i := uint32(0); i < count; i++ {
+    if len(raw) < 2 {
+      return Tree{}, errors.New("tree data is too short to contain an entry")
+    }
+    n := int(binary.BigEndian.Uint16(raw[0:2])) + 2
+    sum := 0
+    if version >= 0x1 {
+      sum = sha256.Size
+    }
+    if len(raw) < n + sum + 16 {
+      return Tree{}, errors.New("tree data is too short to contain an entry")
+    }
+
+    e := Entry{}
+    e.Path = string(raw[2:n])
+    e.Mode = os.FileMode(binary.BigEndian.Uint32(raw[n:n+4]))
+    e.Size = int64(binary.BigEndian.Uint64(raw[n+4:n+12]))
+    copy(e.Sum[:], raw[n+12:n+12+sum])
+    n += sum
+    l := uint64(binary.BigEndian.Uint32(raw[n+12:n+16]))
+    raw = raw[n+16:]
+
+    if l > uint64(len(raw)) {
+      return Tree{}, errors.New("tree entry digest is longer than the remaining data")
+    }
+    d, err := digest.LoadOptions{ Strict: true }.Load(raw[:l])
+    if err != nil {
+      return Tree{}, err
+    }
+    e.Digest = d
+    raw = raw[l:]
+
+    if k := len(t.Entries); k > 0 && t.Entries[k-1].Path >= e.Path {
+      return Tree{}, errors.New("tree entries are not sorted by path")
+    }
+    t.Entries = append(t.Entries, e)
+  }
+
+  if len(raw) != 0 {
+    return Tree{}, errors.New("tree data continues past its last entry")
+  }
+  return t, nil
+}
@@ -0,0 +1,194 @@
+package tree_test
+
+import(
+  "testing"
+  "github.com/pjrebsch/mizudiff/digest"
+  "github.com/pjrebsch/mizudiff/tree"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "bytes"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+  for name, data := range files {
+    path := filepath.Join(root, filepath.FromSlash(name))
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+      t.Fatal(err)
+    }
+    if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+}
+
+func paths(tr tree.Tree) []string {
+  ps := []string{}
+  for _, e := range tr.Entries {
+    ps = append(ps, e.Path)
+  }
+  return ps
+}
+
+func TestAdd(t *testing.T) {
+  tr := tree.Tree{}
+  for _, p := range []string{ "b", "a/z", "c", "a/y", "b" } {
+    if err := tr.Add(p, 0644, []byte(p)); err != nil {
+      t.Fatalf("Add(%s): did not expect an error, but got one: %v", p, err)
+    }
+  }
+
+  expected := []string{ "a/y", "a/z", "b", "c" }
+  if actual := paths(tr); !reflect.DeepEqual(actual, expected) {
+    t.Errorf("Add(): expected paths %v, got %v", expected, actual)
+  }
+
+  e, ok := tr.Find("a/z")
+  if !ok || e.Size != 3 {
+    t.Errorf("Find(a/z): expected an entry of size 3, got %v, %v", e, ok)
+  }
+  if _, ok := tr.Find("a"); ok {
+    t.Errorf("Find(a): expected no entry")
+  }
+}
+
+func TestWalk(t *testing.T) {
+  root := t.TempDir()
+  writeFiles(t, root, map[string]string{
+    "README": "hello",
+    "src/main.c": "int main() {}",
+    "src/lib/util.c": "",
+  })
+
+  tr, err := tree.Walk(root)
+  if err != nil {
+    t.Fatalf("Walk(): did not expect an error, but got one: %v", err)
+  }
+
+  expected := []string{ "README", "src/lib/util.c", "src/main.c" }
+  if actual := paths(tr); !reflect.DeepEqual(actual, expected) {
+    t.Errorf("Walk(): expected paths %v, got %v", expected, actual)
+  }
+
+  e, _ := tr.Find("src/main.c")
+  if e.Size != 13 || !e.Mode.IsRegular() {
+    t.Errorf("Walk(): expected a regular file of size 13, got %v", e)
+  }
+}
+
+func TestLoad(t *testing.T) {
+  tr := tree.Tree{}
+  tr.Add("one", 0644, []byte{ 0x01, 0x02, 0x03 })
+  tr.Add("two", 0755, []byte{})
+
+  raw, err := tr.Bytes()
+  if err != nil {
+    t.Fatalf("Bytes(): did not expect an error, but got one: %v", err)
+  }
+
+  loaded, err := tree.Load(raw)
+  if err != nil {
+    t.Fatalf("Load(): did not expect an error, but got one: %v", err)
+  }
+
+  again, _ := loaded.Bytes()
+  if !bytes.Equal(raw, again) {
+    t.Errorf("Load(): expected to round trip 0x%02x, got 0x%02x", raw, again)
+  }
+
+  var tbl = []struct {
+    name string
+    raw []byte
+  }{
+    {"missing magic", raw[1:]},
+    {"too short for a header", raw[:6]},
+    {"too short for an entry", raw[:len(raw)-3]},
+    {"trailing data", append(append([]byte{}, raw...), 0x00)},
+  }
+  for _, e := range tbl {
+    if _, err := tree.Load(e.raw); err == nil {
+      t.Errorf("Load(%s): expected an error, but didn't get one", e.name)
+    }
+  }
+}
+
+func TestCompare(t *testing.T) {
+  a, b := tree.Tree{}, tree.Tree{}
+
+  same := bytes.Repeat([]byte{ 0x5a }, 64)
+  changed := append([]byte{}, same...)
+  changed[40] = 0x00
+
+  a.Add("kept", 0644, same)
+  b.Add("kept", 0644, same)
+  a.Add("changed", 0644, same)
+  b.Add("changed", 0644, changed)
+  a.Add("chmod", 0644, same)
+  b.Add("chmod", 0755, same)
+  a.Add("removed", 0644, same)
+  b.Add("added", 0644, same)
+
+  cs, err := tree.Compare(a, b)
+  if err != nil {
+    t.Fatalf("Compare(): did not expect an error, but got one: %v", err)
+  }
+
+  expected := []struct {
+    path string
+    kind tree.ChangeKind
+  }{
+    {"added", tree.Added},
+    {"changed", tree.Changed},
+    {"chmod", tree.Changed},
+    {"removed", tree.Removed},
+  }
+  if len(cs) != len(expected) {
+    t.Fatalf("Compare(): expected %d changes, got %v", len(expected), cs)
+  }
+  for i, e := range expected {
+    if cs[i].Path != e.path || cs[i].Kind != e.kind {
+      t.Errorf("Compare(): expected %s %s, got %s %s",
+        e.kind, e.path, cs[i].Kind, cs[i].Path)
+    }
+  }
+
+  rs := cs[1].Regions
+  if len(rs) != 1 || rs[0].Offset > 40 || rs[0].End() <= 40 {
+    t.Errorf("Compare(): expected a region covering byte 40, got %v", rs)
+  }
+  if cs[2].ModeB != 0755 || len(cs[2].Regions) != 0 {
+    t.Errorf("Compare(): expected only a mode change, got %v", cs[2])
+  }
+
+  // The digests are lossy, so matching digests aren't enough when the sums
+  // differ or aren't known.
+  e, _ := a.Find("kept")
+  for _, sum := range [][32]byte{ { 0x01 }, {} } {
+    other := e
+    other.Sum = sum
+    cs, err := tree.Compare(tree.Tree{ Entries: []tree.Entry{ e } },
+      tree.Tree{ Entries: []tree.Entry{ other } })
+    if err != nil {
+      t.Fatalf("Compare(): did not expect an error, but got one: %v", err)
+    }
+    if len(cs) != 1 || len(cs[0].Regions) != 1 || cs[0].Regions[0].Length != e.Size {
+      t.Errorf("Compare(sum %x): expected the whole file to change, got %v", sum[:1], cs)
+    }
+  }
+
+  // Digests that upgrade to one another still line up.
+  upgraded := e
+  upgraded.Digest, err = digest.Upgrade(e.Digest, 0x1)
+  if err != nil {
+    t.Fatalf("Upgrade(): did not expect an error, but got one: %v", err)
+  }
+  cs, err = tree.Compare(tree.Tree{ Entries: []tree.Entry{ e } },
+    tree.Tree{ Entries: []tree.Entry{ upgraded } })
+  if err != nil {
+    t.Fatalf("Compare(): did not expect an error, but got one: %v", err)
+  }
+  if len(cs) != 0 {
+    t.Errorf("Compare(): expected an upgraded digest to match, got %v", cs)
+  }
+}